@@ -0,0 +1,26 @@
+package linode
+
+// alertUnset is the flag value of an alert which is left as is
+const alertUnset = -1
+
+// alertUpdates returns the linode.update arguments for the alert thresholds
+// which were set, a threshold of 0 disables the alert
+func (d *Driver) alertUpdates() map[string]interface{} {
+	args := make(map[string]interface{})
+	for name, threshold := range map[string]int{
+		"cpu":    d.AlertCPU,
+		"bwin":   d.AlertNetworkIn,
+		"bwout":  d.AlertNetworkOut,
+		"diskio": d.AlertIO,
+	} {
+		if threshold == alertUnset {
+			continue
+		}
+
+		args["Alert_"+name+"_enabled"] = threshold > 0
+		if threshold > 0 {
+			args["Alert_"+name+"_threshold"] = threshold
+		}
+	}
+	return args
+}
@@ -0,0 +1,71 @@
+package linode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAlertUpdates(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{name: "unset", expected: map[string]interface{}{}},
+		{
+			name: "thresholds",
+			values: map[string]interface{}{
+				"linode-alert-cpu":         90,
+				"linode-alert-network-in":  5,
+				"linode-alert-network-out": 10,
+				"linode-alert-io":          2000,
+			},
+			expected: map[string]interface{}{
+				"Alert_cpu_enabled":      true,
+				"Alert_cpu_threshold":    90,
+				"Alert_bwin_enabled":     true,
+				"Alert_bwin_threshold":   5,
+				"Alert_bwout_enabled":    true,
+				"Alert_bwout_threshold":  10,
+				"Alert_diskio_enabled":   true,
+				"Alert_diskio_threshold": 2000,
+			},
+		},
+		{
+			name: "disabled",
+			values: map[string]interface{}{
+				"linode-alert-cpu": 0,
+				"linode-alert-io":  0,
+			},
+			expected: map[string]interface{}{
+				"Alert_cpu_enabled":    false,
+				"Alert_diskio_enabled": false,
+			},
+		},
+		{
+			name: "mixed",
+			values: map[string]interface{}{
+				"linode-alert-network-in":  0,
+				"linode-alert-network-out": 25,
+			},
+			expected: map[string]interface{}{
+				"Alert_bwin_enabled":    false,
+				"Alert_bwout_enabled":   true,
+				"Alert_bwout_threshold": 25,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			if err := d.SetConfigFromFlags(newTestFlags(d, test.values)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if updates := d.alertUpdates(); !reflect.DeepEqual(updates, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, updates)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package linode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/taoh/linodego"
+)
+
+// ListDataCenters returns the data centers (regions) linodes can be created in
+func (d *Driver) ListDataCenters() ([]linodego.DataCenter, error) {
+	response, err := d.getClient().Avail.DataCenters()
+	if err != nil {
+		return nil, err
+	}
+	return response.DataCenters, nil
+}
+
+// ListPlans returns the available linode plans (instance types)
+func (d *Driver) ListPlans() ([]linodego.LinodePlan, error) {
+	response, err := d.getClient().Avail.LinodePlans()
+	if err != nil {
+		return nil, err
+	}
+	return response.LinodePlans, nil
+}
+
+// ListDistributions returns the distributions (images) which can be deployed
+func (d *Driver) ListDistributions() ([]linodego.Distribution, error) {
+	response, err := d.getClient().Avail.Distributions()
+	if err != nil {
+		return nil, err
+	}
+	return response.Distributions, nil
+}
+
+// planClasses match plan labels to a class of plans
+var planClasses = map[string]func(label string) bool{
+	"standard": func(label string) bool {
+		return !strings.Contains(label, "High Memory") && !strings.Contains(label, "Dedicated")
+	},
+	"highmem": func(label string) bool {
+		return strings.Contains(label, "High Memory")
+	},
+	"dedicated": func(label string) bool {
+		return strings.Contains(label, "Dedicated")
+	},
+}
+
+// resolvePlanClass sets PlanId to the smallest plan of the class with at
+// least the minimum memory and cores
+func (d *Driver) resolvePlanClass() error {
+	plans, err := d.ListPlans()
+	if err != nil {
+		return err
+	}
+
+	matches := planClasses[d.PlanClass]
+	var best *linodego.LinodePlan
+	for i, plan := range plans {
+		if !matches(plan.Label) || plan.RAM < d.MinMemory || plan.Cores < d.MinCores {
+			continue
+		}
+		if best == nil || plan.RAM < best.RAM || (plan.RAM == best.RAM && plan.Cores < best.Cores) {
+			best = &plans[i]
+		}
+	}
+
+	if best == nil {
+		return fmt.Errorf("no %s plan has at least %dMB memory and %d cores", d.PlanClass, d.MinMemory, d.MinCores)
+	}
+
+	log.Infof("Using plan %s (%d)", best.Label, best.PlanId)
+	d.PlanId = best.PlanId
+	return nil
+}
+
+// eolDistributions are end of life distributions the API still offers
+var eolDistributions = map[int]string{
+	140: "Debian 8",
+	146: "Ubuntu 16.04 LTS",
+}
+
+// checkDistribution fails when the distribution isn't offered, and warns
+// when it is end of life. With --linode-disallow-eol-image that is an error
+// instead.
+func (d *Driver) checkDistribution() error {
+	distributions, err := d.ListDistributions()
+	if err != nil {
+		return err
+	}
+
+	offered := false
+	var available, supported []string
+	for _, distribution := range distributions {
+		if distribution.DistributionId == d.DistributionId {
+			offered = true
+		}
+		label := fmt.Sprintf("%d (%s)", distribution.DistributionId, distribution.Label)
+		available = append(available, label)
+		if _, eol := eolDistributions[distribution.DistributionId]; !eol {
+			supported = append(supported, label)
+		}
+	}
+
+	if !offered {
+		return fmt.Errorf("Linode distribution %d is not offered, use one of: %s",
+			d.DistributionId, strings.Join(available, ", "))
+	}
+
+	label, eol := eolDistributions[d.DistributionId]
+	if !eol {
+		return nil
+	}
+
+	message := fmt.Sprintf("Linode distribution %d (%s) is end of life", d.DistributionId, label)
+	if len(supported) > 0 {
+		message += ", use one of: " + strings.Join(supported, ", ")
+	}
+	if d.DisallowEOLImage {
+		return fmt.Errorf("%s", message)
+	}
+	log.Warn(message)
+	return nil
+}
@@ -0,0 +1,190 @@
+package linode
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// availAPI returns a fake API listing data centers, plans and distributions
+func availAPI() *fakeAPI {
+	return &fakeAPI{handlers: map[string]func(url.Values) string{
+		"avail.datacenters": respond("avail.datacenters", `[
+			{"DATACENTERID":2,"LOCATION":"Dallas, TX, USA","ABBR":"dallas"},
+			{"DATACENTERID":7,"LOCATION":"London, England, UK","ABBR":"london"}
+		]`),
+		"avail.linodeplans": respond("avail.linodeplans", `[
+			{"PLANID":1,"LABEL":"Linode 2048","RAM":2048,"DISK":48,"CORES":1},
+			{"PLANID":2,"LABEL":"Linode 4096","RAM":4096,"DISK":96,"CORES":2},
+			{"PLANID":3,"LABEL":"Linode 8192","RAM":8192,"DISK":192,"CORES":4},
+			{"PLANID":11,"LABEL":"Linode High Memory 16384","RAM":16384,"DISK":20,"CORES":1},
+			{"PLANID":12,"LABEL":"Linode High Memory 32768","RAM":32768,"DISK":40,"CORES":2},
+			{"PLANID":21,"LABEL":"Dedicated 4096","RAM":4096,"DISK":80,"CORES":2},
+			{"PLANID":22,"LABEL":"Dedicated 8192","RAM":8192,"DISK":160,"CORES":4}
+		]`),
+		"avail.distributions": respond("avail.distributions", `[
+			{"DISTRIBUTIONID":140,"LABEL":"Debian 8","IS64BIT":1},
+			{"DISTRIBUTIONID":146,"LABEL":"Ubuntu 16.04 LTS","IS64BIT":1},
+			{"DISTRIBUTIONID":154,"LABEL":"Debian 10","IS64BIT":1}
+		]`),
+	}}
+}
+
+func TestListAvail(t *testing.T) {
+	d := newTestDriver(availAPI())
+
+	dataCenters, err := d.ListDataCenters()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var abbrs []string
+	for _, dataCenter := range dataCenters {
+		abbrs = append(abbrs, fmt.Sprintf("%d %s %s", dataCenter.DataCenterId, dataCenter.Abbr, dataCenter.Location))
+	}
+	if expected := []string{"2 dallas Dallas, TX, USA", "7 london London, England, UK"}; !reflect.DeepEqual(abbrs, expected) {
+		t.Errorf("expected data centers %v, got %v", expected, abbrs)
+	}
+
+	plans, err := d.ListPlans()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var planIds []int
+	for _, plan := range plans {
+		planIds = append(planIds, plan.PlanId)
+	}
+	if expected := []int{1, 2, 3, 11, 12, 21, 22}; !reflect.DeepEqual(planIds, expected) {
+		t.Errorf("expected plans %v, got %v", expected, planIds)
+	}
+	if plans[1].Label != "Linode 4096" || plans[1].RAM != 4096 || plans[1].Disk != 96 || plans[1].Cores != 2 {
+		t.Errorf("expected plan 2 with 4096MB, 96GB and 2 cores, got %+v", plans[1])
+	}
+
+	distributions, err := d.ListDistributions()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var labels []string
+	for _, distribution := range distributions {
+		labels = append(labels, fmt.Sprintf("%d %s", distribution.DistributionId, distribution.Label))
+	}
+	if expected := []string{"140 Debian 8", "146 Ubuntu 16.04 LTS", "154 Debian 10"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected distributions %v, got %v", expected, labels)
+	}
+}
+
+func TestListAvailErrors(t *testing.T) {
+	d := newTestDriver(&fakeAPI{handlers: map[string]func(url.Values) string{
+		"avail.datacenters":   failure("avail.datacenters", "Authentication failed"),
+		"avail.linodeplans":   failure("avail.linodeplans", "Authentication failed"),
+		"avail.distributions": failure("avail.distributions", "Authentication failed"),
+	}})
+
+	if _, err := d.ListDataCenters(); err == nil || !strings.Contains(err.Error(), "Authentication failed") {
+		t.Errorf("expected the data centers API error, got %v", err)
+	}
+	if _, err := d.ListPlans(); err == nil || !strings.Contains(err.Error(), "Authentication failed") {
+		t.Errorf("expected the plans API error, got %v", err)
+	}
+	if _, err := d.ListDistributions(); err == nil || !strings.Contains(err.Error(), "Authentication failed") {
+		t.Errorf("expected the distributions API error, got %v", err)
+	}
+}
+
+func TestResolvePlanClass(t *testing.T) {
+	tests := []struct {
+		class     string
+		minMemory int
+		minCores  int
+		planId    int
+		err       string
+	}{
+		{class: "standard", planId: 1},
+		{class: "standard", minMemory: 3000, planId: 2},
+		{class: "standard", minCores: 3, planId: 3},
+		{class: "highmem", planId: 11},
+		{class: "highmem", minCores: 2, planId: 12},
+		{class: "dedicated", minMemory: 4096, minCores: 4, planId: 22},
+		{class: "standard", minMemory: 16384, err: "no standard plan has at least 16384MB memory and 0 cores"},
+		{class: "dedicated", minCores: 8, err: "no dedicated plan has at least 0MB memory and 8 cores"},
+	}
+
+	for _, test := range tests {
+		d := newTestDriver(availAPI())
+		d.PlanId = 1
+		d.PlanClass = test.class
+		d.MinMemory = test.minMemory
+		d.MinCores = test.minCores
+
+		err := d.resolvePlanClass()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s %dMB %d cores: expected error %q, got %v", test.class, test.minMemory, test.minCores, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %dMB %d cores: unexpected error: %s", test.class, test.minMemory, test.minCores, err)
+			continue
+		}
+		if d.PlanId != test.planId {
+			t.Errorf("%s %dMB %d cores: expected plan %d, got %d", test.class, test.minMemory, test.minCores, test.planId, d.PlanId)
+		}
+	}
+}
+
+func TestCheckDistribution(t *testing.T) {
+	tests := []struct {
+		name           string
+		distributionId int
+		disallowEOL    bool
+		warning        string
+		err            string
+	}{
+		{name: "supported", distributionId: 154},
+		{
+			name:           "end of life",
+			distributionId: 140,
+			warning:        "Linode distribution 140 (Debian 8) is end of life, use one of: 154 (Debian 10)",
+		},
+		{
+			name:           "end of life disallowed",
+			distributionId: 146,
+			disallowEOL:    true,
+			err:            "Linode distribution 146 (Ubuntu 16.04 LTS) is end of life, use one of: 154 (Debian 10)",
+		},
+		{
+			name:           "not offered",
+			distributionId: 999,
+			err:            "Linode distribution 999 is not offered, use one of: 140 (Debian 8), 146 (Ubuntu 16.04 LTS), 154 (Debian 10)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLog(t)
+			d := newTestDriver(availAPI())
+			d.DistributionId = test.distributionId
+			d.DisallowEOLImage = test.disallowEOL
+
+			err := d.checkDistribution()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if warned := strings.Contains(logs.String(), "end of life"); warned != (test.warning != "") {
+				t.Errorf("expected warning %q, got logs %q", test.warning, logs.String())
+			}
+			if !strings.Contains(logs.String(), test.warning) {
+				t.Errorf("expected warning %q, got %q", test.warning, logs.String())
+			}
+		})
+	}
+}
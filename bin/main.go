@@ -1,10 +1,19 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/docker/machine/libmachine/drivers/plugin"
 	linode "github.com/taoh/docker-machine-linode"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Printf("docker-machine-driver-linode %s (%s)\n", linode.GetVersion(), strings.Join(linode.Capabilities(), ", "))
+		return
+	}
+
 	plugin.RegisterDriver(linode.NewDriver("", ""))
 }
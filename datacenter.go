@@ -0,0 +1,146 @@
+package linode
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/taoh/linodego"
+)
+
+// measureLatency is replaceable to measure the latency to a data center
+var measureLatency = func(dataCenter linodego.DataCenter) (time.Duration, error) {
+	address := net.JoinHostPort(fmt.Sprintf("speedtest.%s.linode.com", dataCenter.Abbr), "80")
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+// selectFastestDataCenter sets DataCenterId to the data center with the
+// lowest latency, keeping the configured one when nothing could be measured
+func (d *Driver) selectFastestDataCenter() {
+	dataCenters, err := d.ListDataCenters()
+	if err != nil {
+		log.Warnf("Listing data centers failed, using data center %d: %s", d.DataCenterId, err)
+		return
+	}
+
+	latencies := make([]time.Duration, len(dataCenters))
+	var wg sync.WaitGroup
+	for i, dataCenter := range dataCenters {
+		wg.Add(1)
+		go func(i int, dataCenter linodego.DataCenter) {
+			defer wg.Done()
+			latency, err := measureLatency(dataCenter)
+			if err != nil {
+				log.Debugf("Measuring latency to %s failed: %s", dataCenter.Abbr, err)
+				return
+			}
+			latencies[i] = latency
+		}(i, dataCenter)
+	}
+	wg.Wait()
+
+	fastest := -1
+	for i, latency := range latencies {
+		if latency > 0 && (fastest < 0 || latency < latencies[fastest]) {
+			fastest = i
+		}
+	}
+	if fastest < 0 {
+		log.Warnf("Measuring data center latency failed, using data center %d", d.DataCenterId)
+		return
+	}
+
+	d.DataCenterId = dataCenters[fastest].DataCenterId
+	log.Infof("Using data center %s (%s), %s away", dataCenters[fastest].Abbr, dataCenters[fastest].Location, latencies[fastest])
+}
+
+// isCapacityError reports whether err is the API error for a data center
+// without room for the plan
+func isCapacityError(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "no open slots") || strings.Contains(message, "capacity")
+}
+
+// country returns the country of a data center location such as
+// "Newark, NJ, USA"
+func country(location string) string {
+	parts := strings.Split(location, ",")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+// fallbackDataCenters returns the other data centers in the same country as
+// the configured one
+func (d *Driver) fallbackDataCenters() ([]linodego.DataCenter, error) {
+	dataCenters, err := d.ListDataCenters()
+	if err != nil {
+		return nil, err
+	}
+
+	var current string
+	for _, dataCenter := range dataCenters {
+		if dataCenter.DataCenterId == d.DataCenterId {
+			current = country(dataCenter.Location)
+		}
+	}
+	if current == "" {
+		return nil, fmt.Errorf("Linode data center %d is not found.", d.DataCenterId)
+	}
+
+	var fallbacks []linodego.DataCenter
+	for _, dataCenter := range dataCenters {
+		if dataCenter.DataCenterId != d.DataCenterId && country(dataCenter.Location) == current {
+			fallbacks = append(fallbacks, dataCenter)
+		}
+	}
+	return fallbacks, nil
+}
+
+// createLinode creates the linode in the configured data center. With
+// --linode-capacity-fallback the other data centers in the same country are
+// tried when it is out of capacity, and DataCenterId is set to the one used.
+func (d *Driver) createLinode() (*linodego.LinodeResponse, error) {
+	client := d.getClient()
+
+	linodeResponse, err := client.Linode.Create(
+		d.DataCenterId,
+		d.PlanId,
+		d.PaymentTerm,
+	)
+	if err == nil || !d.CapacityFallback || !isCapacityError(err) {
+		return linodeResponse, err
+	}
+
+	log.Infof("Linode data center %d is out of capacity: %s", d.DataCenterId, err)
+	fallbacks, fallbackErr := d.fallbackDataCenters()
+	if fallbackErr != nil {
+		log.Warnf("Listing fallback data centers failed: %s", fallbackErr)
+		return nil, err
+	}
+
+	for _, dataCenter := range fallbacks {
+		log.Infof("Trying data center %s (%s)", dataCenter.Abbr, dataCenter.Location)
+		linodeResponse, err = client.Linode.Create(
+			dataCenter.DataCenterId,
+			d.PlanId,
+			d.PaymentTerm,
+		)
+		if err == nil {
+			d.DataCenterId = dataCenter.DataCenterId
+			return linodeResponse, nil
+		}
+		if !isCapacityError(err) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
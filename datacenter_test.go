@@ -0,0 +1,178 @@
+package linode
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taoh/linodego"
+)
+
+// capacityAPI returns a fake API whose data centers in full are out of
+// capacity, and records the data centers linodes were created in
+func capacityAPI(full map[string]bool, attempts *[]string) *fakeAPI {
+	return &fakeAPI{handlers: map[string]func(url.Values) string{
+		"avail.datacenters": func(url.Values) string {
+			return apiData("avail.datacenters", `[
+				{"DATACENTERID":2,"LOCATION":"Dallas, TX, USA","ABBR":"dallas"},
+				{"DATACENTERID":3,"LOCATION":"Fremont, CA, USA","ABBR":"fremont"},
+				{"DATACENTERID":6,"LOCATION":"Newark, NJ, USA","ABBR":"newark"},
+				{"DATACENTERID":7,"LOCATION":"London, England, UK","ABBR":"london"}
+			]`)
+		},
+		"linode.create": func(params url.Values) string {
+			dataCenter := params.Get("DatacenterID")
+			*attempts = append(*attempts, dataCenter)
+			if full[dataCenter] {
+				return apiFailure("linode.create", 8, "No open slots for this plan!")
+			}
+			return apiData("linode.create", `{"LinodeID":123}`)
+		},
+	}}
+}
+
+func TestCreateLinodeCapacityFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		fallback   bool
+		dataCenter int
+		full       map[string]bool
+		attempts   []string
+		used       int
+		err        string
+	}{
+		{
+			name:       "created",
+			dataCenter: 2,
+			attempts:   []string{"2"},
+			used:       2,
+		},
+		{
+			name:       "fallback disabled",
+			dataCenter: 2,
+			full:       map[string]bool{"2": true},
+			attempts:   []string{"2"},
+			used:       2,
+			err:        "No open slots for this plan!",
+		},
+		{
+			name:       "fallback in the same country",
+			fallback:   true,
+			dataCenter: 2,
+			full:       map[string]bool{"2": true},
+			attempts:   []string{"2", "3"},
+			used:       3,
+		},
+		{
+			name:       "country full",
+			fallback:   true,
+			dataCenter: 2,
+			full:       map[string]bool{"2": true, "3": true, "6": true},
+			attempts:   []string{"2", "3", "6"},
+			used:       2,
+			err:        "No open slots for this plan!",
+		},
+		{
+			name:       "no fallback in the country",
+			fallback:   true,
+			dataCenter: 7,
+			full:       map[string]bool{"7": true},
+			attempts:   []string{"7"},
+			used:       7,
+			err:        "No open slots for this plan!",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts []string
+			d := newTestDriver(capacityAPI(test.full, &attempts))
+			d.DataCenterId = test.dataCenter
+			d.PlanId = defaultPlanId
+			d.PaymentTerm = 1
+			d.CapacityFallback = test.fallback
+
+			response, err := d.createLinode()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if response.LinodeId.LinodeId != 123 {
+				t.Errorf("expected linode 123, got %d", response.LinodeId.LinodeId)
+			}
+
+			if !reflect.DeepEqual(attempts, test.attempts) {
+				t.Errorf("expected attempts in %v, got %v", test.attempts, attempts)
+			}
+			if d.DataCenterId != test.used {
+				t.Errorf("expected data center %d to be recorded, got %d", test.used, d.DataCenterId)
+			}
+		})
+	}
+}
+
+func TestCreateLinodeOtherError(t *testing.T) {
+	var attempts []string
+	api := capacityAPI(nil, &attempts)
+	api.handlers["linode.create"] = func(params url.Values) string {
+		attempts = append(attempts, params.Get("DatacenterID"))
+		return apiFailure("linode.create", 4, "Authentication failed")
+	}
+	d := newTestDriver(api)
+	d.DataCenterId = 2
+	d.CapacityFallback = true
+
+	if _, err := d.createLinode(); err == nil || !strings.Contains(err.Error(), "Authentication failed") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+	if !reflect.DeepEqual(attempts, []string{"2"}) {
+		t.Errorf("expected no fallback, got attempts in %v", attempts)
+	}
+}
+
+func TestSelectFastestDataCenter(t *testing.T) {
+	tests := []struct {
+		name       string
+		latencies  map[string]time.Duration
+		dataCenter int
+	}{
+		{
+			name:       "fastest",
+			latencies:  map[string]time.Duration{"dallas": 40 * time.Millisecond, "fremont": 70 * time.Millisecond, "newark": 10 * time.Millisecond, "london": 90 * time.Millisecond},
+			dataCenter: 6,
+		},
+		{
+			name:       "failed measurements are skipped",
+			latencies:  map[string]time.Duration{"fremont": 70 * time.Millisecond, "london": 90 * time.Millisecond},
+			dataCenter: 3,
+		},
+		{name: "nothing measured", dataCenter: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(measure func(linodego.DataCenter) (time.Duration, error)) { measureLatency = measure }(measureLatency)
+			measureLatency = func(dataCenter linodego.DataCenter) (time.Duration, error) {
+				latency, ok := test.latencies[dataCenter.Abbr]
+				if !ok {
+					return 0, errors.New("i/o timeout")
+				}
+				return latency, nil
+			}
+
+			var attempts []string
+			d := newTestDriver(capacityAPI(nil, &attempts))
+			d.DataCenterId = 2
+
+			d.selectFastestDataCenter()
+			if d.DataCenterId != test.dataCenter {
+				t.Errorf("expected data center %d, got %d", test.dataCenter, d.DataCenterId)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package linode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/taoh/linodego"
+)
+
+// diskTask describes a disk to create on the linode
+type diskTask struct {
+	name   string
+	create func() (*linodego.LinodeDiskJobResponse, error)
+	diskId *int
+}
+
+// createDisks runs the disk tasks with at most MaxParallel of them in flight.
+// All of them are waited for, and if any fails the disks which were created
+// are deleted again before the errors are returned.
+func (d *Driver) createDisks(tasks []diskTask) error {
+	maxParallel := d.MaxParallel
+	if maxParallel <= 0 {
+		maxParallel = 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []string
+		tokens = make(chan struct{}, maxParallel)
+	)
+
+	for _, task := range tasks {
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func(task diskTask) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+
+			if err := d.createDisk(task); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %s", task.name, err))
+				mu.Unlock()
+			}
+		}(task)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	for _, task := range tasks {
+		if *task.diskId == 0 {
+			continue
+		}
+		log.Debugf("Deleting disk %d of failed create", *task.diskId)
+		if _, err := d.getClient().Disk.Delete(d.LinodeId, *task.diskId); err != nil {
+			log.Warnf("Failed to delete disk %d: %s", *task.diskId, err)
+			continue
+		}
+		*task.diskId = 0
+	}
+
+	return fmt.Errorf("creating disks failed: %s", strings.Join(errs, "; "))
+}
+
+func (d *Driver) createDisk(task diskTask) error {
+	log.Debugf("Create %s", task.name)
+	createDiskJobResponse, err := task.create()
+	if err != nil {
+		return err
+	}
+
+	jobId := createDiskJobResponse.DiskJob.JobId
+	*task.diskId = createDiskJobResponse.DiskJob.DiskId
+	log.Debugf("Linode create %s task :%d.", task.name, jobId)
+
+	// wait until the creation is finished
+	return d.waitForJob(jobId, "Create "+task.name, d.DeployTimeout)
+}
+
+const (
+	maxSwapSize          = 2048
+	defaultSwapSizeRatio = 0.5
+)
+
+// resolveSwapSize sets SwapSize from the --linode-swap-size option, which is
+// either a size in MB, "auto" or "auto:<ratio>" of the memory of the plan
+func (d *Driver) resolveSwapSize() error {
+	if !strings.HasPrefix(d.SwapSizeOption, "auto") {
+		return nil
+	}
+
+	ratio := defaultSwapSizeRatio
+	if d.SwapSizeOption != "auto" {
+		var err error
+		ratio, err = parseSwapSizeRatio(d.SwapSizeOption)
+		if err != nil {
+			return err
+		}
+	}
+
+	plans, err := d.ListPlans()
+	if err != nil {
+		return err
+	}
+	for _, plan := range plans {
+		if plan.PlanId == d.PlanId {
+			d.SwapSize = int(float64(plan.RAM) * ratio)
+			if d.SwapSize > maxSwapSize {
+				d.SwapSize = maxSwapSize
+			}
+			log.Debugf("Using %dMB swap for %dMB of memory", d.SwapSize, plan.RAM)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Linode plan %d is not found.", d.PlanId)
+}
+
+// parseSwapSize validates the --linode-swap-size option and returns the size
+// in MB, or 0 when it is resolved from the plan later
+func parseSwapSize(option string) (int, error) {
+	if option == "auto" {
+		return 0, nil
+	}
+	if strings.HasPrefix(option, "auto:") {
+		_, err := parseSwapSizeRatio(option)
+		return 0, err
+	}
+
+	size, err := strconv.Atoi(option)
+	if err != nil || size < 1 || size >= totalDiskSize {
+		return 0, fmt.Errorf("--linode-swap-size must be a size in MB from 1 to %d, \"auto\" or \"auto:<ratio>\", got %q", totalDiskSize-1, option)
+	}
+	return size, nil
+}
+
+func parseSwapSizeRatio(option string) (float64, error) {
+	ratio, err := strconv.ParseFloat(strings.TrimPrefix(option, "auto:"), 64)
+	if err != nil || ratio <= 0 || ratio > 2 {
+		return 0, fmt.Errorf("--linode-swap-size ratio must be above 0 and at most 2, got %q", option)
+	}
+	return ratio, nil
+}
+
+// primaryDiskSize is the space left for the primary disk next to the swap
+func (d *Driver) primaryDiskSize() int {
+	return totalDiskSize - d.SwapSize
+}
+
+// checkDataDiskSize verifies the data disk fits on the plan next to the
+// primary and swap disks
+func (d *Driver) checkDataDiskSize() error {
+	plans, err := d.ListPlans()
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		if plan.PlanId != d.PlanId {
+			continue
+		}
+		// plan disk space is in GB
+		available := plan.Disk*1024 - totalDiskSize
+		if d.DataDiskSize > available {
+			return fmt.Errorf("--linode-data-disk-size %dMB doesn't fit on plan %s, at most %dMB is left next to the primary and swap disks",
+				d.DataDiskSize, plan.Label, available)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Linode plan %d is not found.", d.PlanId)
+}
+
+// configDevices maps the disks to devices in --linode-config-devices order,
+// returning the DiskList and the root device number for the config profile
+func (d *Driver) configDevices() (string, int, error) {
+	ids := map[string]int{
+		"root": d.DiskId,
+		"swap": d.SwapDiskId,
+		"data": d.DataDiskId,
+	}
+
+	var disks []string
+	rootDevice := 0
+	for i, role := range strings.Split(d.ConfigDevices, ",") {
+		id, ok := ids[role]
+		if !ok || id == 0 {
+			return "", 0, fmt.Errorf("--linode-config-devices has no %q disk", role)
+		}
+		if role == "root" {
+			rootDevice = i + 1
+		}
+		disks = append(disks, strconv.Itoa(id))
+	}
+
+	return strings.Join(disks, ","), rootDevice, nil
+}
+
+// validateConfigDevices checks --linode-config-devices names each disk once
+// and includes the root disk
+func validateConfigDevices(devices string, dataDisk bool) error {
+	seen := make(map[string]bool)
+	for _, role := range strings.Split(devices, ",") {
+		switch role {
+		case "root", "swap":
+		case "data":
+			if !dataDisk {
+				return fmt.Errorf("--linode-config-devices maps a data disk without --linode-data-disk-size")
+			}
+		default:
+			return fmt.Errorf("--linode-config-devices must list root, swap and data, got %q", role)
+		}
+		if seen[role] {
+			return fmt.Errorf("--linode-config-devices lists %s twice", role)
+		}
+		seen[role] = true
+	}
+
+	if !seen["root"] {
+		return fmt.Errorf("--linode-config-devices must include the root disk")
+	}
+	return nil
+}
+
+// checkConfigMemoryLimit verifies the memory limit doesn't exceed the plan
+func (d *Driver) checkConfigMemoryLimit() error {
+	plans, err := d.ListPlans()
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		if plan.PlanId != d.PlanId {
+			continue
+		}
+		if d.ConfigMemoryLimit > plan.RAM {
+			return fmt.Errorf("--linode-config-memory-limit %dMB is more than the %dMB of plan %s",
+				d.ConfigMemoryLimit, plan.RAM, plan.Label)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Linode plan %d is not found.", d.PlanId)
+}
@@ -0,0 +1,346 @@
+package linode
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taoh/linodego"
+)
+
+func TestParseSwapSize(t *testing.T) {
+	tests := []struct {
+		option string
+		size   int
+		err    string
+	}{
+		{option: "256", size: 256},
+		{option: "1", size: 1},
+		{option: "20479", size: 20479},
+		{option: "auto", size: 0},
+		{option: "auto:0.25", size: 0},
+		{option: "auto:2", size: 0},
+		{option: "0", err: "--linode-swap-size must be a size in MB from 1 to 20479"},
+		{option: "20480", err: "--linode-swap-size must be a size in MB from 1 to 20479"},
+		{option: "512MB", err: "--linode-swap-size must be a size in MB from 1 to 20479"},
+		{option: "auto:0", err: "--linode-swap-size ratio must be above 0 and at most 2"},
+		{option: "auto:3", err: "--linode-swap-size ratio must be above 0 and at most 2"},
+		{option: "auto:half", err: "--linode-swap-size ratio must be above 0 and at most 2"},
+	}
+
+	for _, test := range tests {
+		size, err := parseSwapSize(test.option)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got %v", test.option, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.option, err)
+			continue
+		}
+		if size != test.size {
+			t.Errorf("%q: expected %dMB, got %dMB", test.option, test.size, size)
+		}
+	}
+}
+
+func TestResolveSwapSize(t *testing.T) {
+	tests := []struct {
+		option string
+		planId int
+		size   int
+		err    string
+	}{
+		{option: "512", planId: 1, size: 512},
+		{option: "auto", planId: 1, size: 512},
+		{option: "auto:1", planId: 2, size: 2048},
+		{option: "auto:0.25", planId: 2, size: 512},
+		// capped at maxSwapSize
+		{option: "auto", planId: 4, size: maxSwapSize},
+		{option: "auto", planId: 9, err: "Linode plan 9 is not found."},
+	}
+
+	for _, test := range tests {
+		api := &fakeAPI{handlers: map[string]func(url.Values) string{
+			"avail.linodeplans": func(url.Values) string {
+				return apiData("avail.linodeplans", `[
+					{"PLANID":1,"LABEL":"Linode 1024","RAM":1024,"DISK":24,"CORES":1},
+					{"PLANID":2,"LABEL":"Linode 2048","RAM":2048,"DISK":48,"CORES":1},
+					{"PLANID":4,"LABEL":"Linode 8192","RAM":8192,"DISK":192,"CORES":4}
+				]`)
+			},
+		}}
+		d := newTestDriver(api)
+		d.PlanId = test.planId
+		d.SwapSizeOption = test.option
+		d.SwapSize, _ = parseSwapSize(test.option)
+
+		err := d.resolveSwapSize()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s on plan %d: expected error %q, got %v", test.option, test.planId, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s on plan %d: unexpected error: %s", test.option, test.planId, err)
+			continue
+		}
+		if d.SwapSize != test.size {
+			t.Errorf("%s on plan %d: expected %dMB, got %dMB", test.option, test.planId, test.size, d.SwapSize)
+		}
+	}
+}
+
+func TestCreateDisks(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+	)
+	api := &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.disk.create": func(params url.Values) string {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxSeen {
+				maxSeen = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			label := params.Get("Label")
+			if label == "Disk 3" {
+				return apiFailure("linode.disk.create", 0, "Not enough space")
+			}
+			id := strings.TrimPrefix(label, "Disk ")
+			return apiData("linode.disk.create", `{"JobID":1`+id+`,"DiskID":2`+id+`}`)
+		},
+		"linode.job.list":    jobsFinished,
+		"linode.disk.delete": respond("linode.disk.delete", `{"JobID":30}`),
+	}}
+	d := newTestDriver(api)
+	d.LinodeId = 42
+	d.MaxParallel = 2
+	d.DeployTimeout = 5
+
+	diskIds := make([]int, 5)
+	var tasks []diskTask
+	for i := range diskIds {
+		label := fmt.Sprintf("Disk %d", i+1)
+		tasks = append(tasks, diskTask{
+			name: label,
+			create: func() (*linodego.LinodeDiskJobResponse, error) {
+				return d.getClient().Disk.Create(d.LinodeId, "ext4", label, 1024, nil)
+			},
+			diskId: &diskIds[i],
+		})
+	}
+
+	err := d.createDisks(tasks)
+	if err == nil || err.Error() != "creating disks failed: Disk 3: Not enough space" {
+		t.Errorf("expected the failed disk to be reported, got %v", err)
+	}
+
+	if maxSeen > d.MaxParallel {
+		t.Errorf("expected at most %d disks created in parallel, got %d", d.MaxParallel, maxSeen)
+	}
+	if maxSeen < d.MaxParallel {
+		t.Errorf("expected disks to be created in parallel, got %d at once", maxSeen)
+	}
+
+	var deleted []string
+	for _, params := range api.requests("linode.disk.delete") {
+		deleted = append(deleted, params.Get("DiskID"))
+	}
+	sort.Strings(deleted)
+	if expected := []string{"21", "22", "24", "25"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected the created disks %v to be deleted, got %v", expected, deleted)
+	}
+	for i, id := range diskIds {
+		if id != 0 {
+			t.Errorf("expected the ID of disk %d to be reset, got %d", i+1, id)
+		}
+	}
+}
+
+// deployAPI returns a fake API deploying disk 21 from the distribution, and
+// the swap and data disks 22 and 23, recording the configuration profile
+func deployAPI(config *url.Values) *fakeAPI {
+	diskIds := map[string]int{"Swap Disk": 22, "Data Disk": 23}
+	return &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.disk.createfromdistribution": respond("linode.disk.createfromdistribution", `{"JobID":11,"DiskID":21}`),
+		"linode.disk.create": func(params url.Values) string {
+			diskId := diskIds[params.Get("Label")]
+			return apiData("linode.disk.create", fmt.Sprintf(`{"JobID":%d,"DiskID":%d}`, diskId-10, diskId))
+		},
+		"linode.job.list": jobsFinished,
+		"linode.config.create": func(params url.Values) string {
+			*config = params
+			return apiData("linode.config.create", `{"ConfigID":31}`)
+		},
+	}}
+}
+
+func TestDeployDataDisk(t *testing.T) {
+	for _, filesystem := range []string{"ext4", "ext3", "raw"} {
+		t.Run(filesystem, func(t *testing.T) {
+			var config url.Values
+			api := deployAPI(&config)
+			d := newTestDriver(api)
+			d.LinodeId = 42
+			d.SwapSize = defaultSwapSize
+			d.DataDiskSize = 4096
+			d.DataDiskFilesystem = filesystem
+			d.ConfigDevices = "root,swap,data"
+			d.DeployTimeout = 5
+			d.MaxParallel = 3
+
+			if err := d.deployDisks("ssh-rsa AAAA test"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var data url.Values
+			for _, params := range api.requests("linode.disk.create") {
+				if params.Get("Label") == "Data Disk" {
+					data = params
+				}
+			}
+			if data == nil {
+				t.Fatal("expected the data disk to be created")
+			}
+			if data.Get("Type") != filesystem || data.Get("Size") != "4096" {
+				t.Errorf("expected a 4096MB %s data disk, got %s of %sMB", filesystem, data.Get("Type"), data.Get("Size"))
+			}
+			if d.DataDiskId != 23 {
+				t.Errorf("expected data disk 23, got %d", d.DataDiskId)
+			}
+			if diskList := config.Get("DiskList"); diskList != "21,22,23" {
+				t.Errorf("expected the data disk to be mapped, got %q", diskList)
+			}
+		})
+	}
+}
+
+func TestCheckDataDiskSize(t *testing.T) {
+	tests := []struct {
+		size   int
+		planId int
+		err    string
+	}{
+		{size: 4096, planId: 1},
+		{size: 28672, planId: 2},
+		{
+			size:   4097,
+			planId: 1,
+			err:    "--linode-data-disk-size 4097MB doesn't fit on plan Linode 1024, at most 4096MB is left next to the primary and swap disks",
+		},
+		{size: 1024, planId: 9, err: "Linode plan 9 is not found."},
+	}
+
+	for _, test := range tests {
+		api := &fakeAPI{handlers: map[string]func(url.Values) string{
+			"avail.linodeplans": respond("avail.linodeplans", `[
+				{"PLANID":1,"LABEL":"Linode 1024","RAM":1024,"DISK":24,"CORES":1},
+				{"PLANID":2,"LABEL":"Linode 2048","RAM":2048,"DISK":48,"CORES":1}
+			]`),
+		}}
+		d := newTestDriver(api)
+		d.PlanId = test.planId
+		d.DataDiskSize = test.size
+
+		err := d.checkDataDiskSize()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%dMB on plan %d: expected error %q, got %v", test.size, test.planId, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%dMB on plan %d: unexpected error: %s", test.size, test.planId, err)
+		}
+	}
+}
+
+func TestDeployDisksConfig(t *testing.T) {
+	tests := []struct {
+		devices     string
+		dataDisk    int
+		memoryLimit int
+		diskList    string
+		rootDevice  string
+		ramLimit    string
+	}{
+		{devices: "root,swap", diskList: "21,22", rootDevice: "1"},
+		{devices: "swap,root", memoryLimit: 1024, diskList: "22,21", rootDevice: "2", ramLimit: "1024"},
+		{devices: "data,root,swap", dataDisk: 4096, memoryLimit: 2048, diskList: "23,21,22", rootDevice: "2", ramLimit: "2048"},
+	}
+
+	for _, test := range tests {
+		var config url.Values
+		d := newTestDriver(deployAPI(&config))
+		d.LinodeId = 42
+		d.SwapSize = defaultSwapSize
+		d.DataDiskSize = test.dataDisk
+		d.DataDiskFilesystem = "ext4"
+		d.ConfigDevices = test.devices
+		d.ConfigMemoryLimit = test.memoryLimit
+		d.DeployTimeout = 5
+		d.MaxParallel = 1
+
+		if err := d.deployDisks("ssh-rsa AAAA test"); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.devices, err)
+			continue
+		}
+		if diskList := config.Get("DiskList"); diskList != test.diskList {
+			t.Errorf("%s: expected disk list %s, got %s", test.devices, test.diskList, diskList)
+		}
+		if rootDevice := config.Get("RootDeviceNum"); rootDevice != test.rootDevice {
+			t.Errorf("%s: expected root device %s, got %s", test.devices, test.rootDevice, rootDevice)
+		}
+		if ramLimit := config.Get("RAMLimit"); ramLimit != test.ramLimit {
+			t.Errorf("%s: expected memory limit %q, got %q", test.devices, test.ramLimit, ramLimit)
+		}
+	}
+}
+
+func TestCheckConfigMemoryLimit(t *testing.T) {
+	tests := []struct {
+		limit  int
+		planId int
+		err    string
+	}{
+		{limit: 1024, planId: 1},
+		{limit: 2048, planId: 1},
+		{limit: 2049, planId: 1, err: "--linode-config-memory-limit 2049MB is more than the 2048MB of plan Linode 2048"},
+		{limit: 1024, planId: 9, err: "Linode plan 9 is not found."},
+	}
+
+	for _, test := range tests {
+		d := newTestDriver(availAPI())
+		d.PlanId = test.planId
+		d.ConfigMemoryLimit = test.limit
+
+		err := d.checkConfigMemoryLimit()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%dMB on plan %d: expected error %q, got %v", test.limit, test.planId, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%dMB on plan %d: unexpected error: %s", test.limit, test.planId, err)
+		}
+	}
+}
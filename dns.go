@@ -0,0 +1,117 @@
+package linode
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// findDomain returns the ID of the DNS domain zone hosted on the account
+func (d *Driver) findDomain(name string) (int, error) {
+	domains, err := d.getClient().Domain.List(-1)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, domain := range domains.Domains {
+		if domain.Domain == name {
+			return domain.DomainId, nil
+		}
+	}
+
+	return 0, fmt.Errorf("DNS domain %s is not found on the account", name)
+}
+
+// createDNSRecord adds an A record for the public IP to the DNS domain
+func (d *Driver) createDNSRecord() error {
+	domainId, err := d.findDomain(d.DNSDomain)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Creating DNS record %s.%s for %s", d.DNSRecord, d.DNSDomain, d.IPAddress)
+	response, err := d.getClient().Resource.Create(domainId, "A", map[string]interface{}{
+		"Name":   d.DNSRecord,
+		"Target": d.IPAddress,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.DNSDomainId = domainId
+	d.DNSResourceId = response.ResourceId.ResourceId
+	return nil
+}
+
+// removeDNSRecord deletes the record created by createDNSRecord
+func (d *Driver) removeDNSRecord() error {
+	if d.DNSResourceId == 0 {
+		return nil
+	}
+
+	log.Debugf("Removing DNS record %s.%s", d.DNSRecord, d.DNSDomain)
+	if _, err := d.getClient().Resource.Delete(d.DNSDomainId, d.DNSResourceId); err != nil {
+		if !isNotFound(err) {
+			return err
+		}
+		log.Warnf("DNS record %s.%s is already deleted", d.DNSRecord, d.DNSDomain)
+	}
+
+	d.DNSResourceId = 0
+	return nil
+}
+
+// lookupHost is replaceable to resolve the rDNS name
+var lookupHost = net.LookupHost
+
+// rdnsTimeout is how many seconds the rDNS name is given to resolve to the
+// IP, a record created with the linode takes a while to propagate
+var rdnsTimeout = 120
+
+// setRDNS sets the reverse DNS of the public IP. Linode requires the name to
+// resolve to the IP first, when it doesn't in time the rDNS is skipped with a
+// warning rather than failing the create.
+func (d *Driver) setRDNS() error {
+	var addresses []string
+	err := waitFor("Resolving rDNS name "+d.RDNS, rdnsTimeout, func() (bool, error) {
+		var err error
+		if addresses, err = lookupHost(d.RDNS); err != nil {
+			log.Debugf("rDNS name %s does not resolve yet: %s", d.RDNS, err)
+			return false, nil
+		}
+		for _, address := range addresses {
+			if address == d.IPAddress {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		resolved := "nothing"
+		if len(addresses) > 0 {
+			resolved = strings.Join(addresses, ", ")
+		}
+		log.Warnf("Skipping rDNS, %s resolves to %s instead of %s, add an A record for it and set the rDNS later: %s",
+			d.RDNS, resolved, d.IPAddress, err)
+		return nil
+	}
+
+	client := d.getClient()
+	ips, err := client.Ip.List(d.LinodeId, -1)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips.FullIPAddresses {
+		if ip.IPAddress != d.IPAddress {
+			continue
+		}
+
+		log.Debugf("Setting rDNS of %s to %s", d.IPAddress, d.RDNS)
+		_, err := client.Ip.SetRDNS(ip.IPAddressId, d.RDNS)
+		return err
+	}
+
+	return fmt.Errorf("IP address %s is not found on linode %d", d.IPAddress, d.LinodeId)
+}
@@ -0,0 +1,228 @@
+package linode
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dnsAPI returns a fake API with the example.com domain, which records the
+// parameters of the created DNS record
+func dnsAPI(created *url.Values) *fakeAPI {
+	return &fakeAPI{handlers: map[string]func(url.Values) string{
+		"domain.list": func(url.Values) string {
+			return apiData("domain.list", `[{"DOMAINID":5,"DOMAIN":"example.com"}]`)
+		},
+		"domain.resource.create": func(params url.Values) string {
+			*created = params
+			return apiData("domain.resource.create", `{"ResourceID":7}`)
+		},
+	}}
+}
+
+func TestCreateDNSRecord(t *testing.T) {
+	var created url.Values
+	d := newTestDriver(dnsAPI(&created))
+	d.IPAddress = "203.0.113.10"
+	d.DNSDomain = "example.com"
+	d.DNSRecord = "node1"
+
+	if err := d.createDNSRecord(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.DNSDomainId != 5 || d.DNSResourceId != 7 {
+		t.Errorf("expected domain 5 and record 7, got %d and %d", d.DNSDomainId, d.DNSResourceId)
+	}
+	for key, expected := range map[string]string{
+		"DomainID": "5",
+		"Type":     "A",
+		"Name":     "node1",
+		"Target":   "203.0.113.10",
+	} {
+		if value := created.Get(key); value != expected {
+			t.Errorf("expected %s %s, got %s", key, expected, value)
+		}
+	}
+}
+
+func TestCreateDNSRecordUnknownDomain(t *testing.T) {
+	var created url.Values
+	d := newTestDriver(dnsAPI(&created))
+	d.DNSDomain = "example.org"
+	d.DNSRecord = "node1"
+
+	err := d.createDNSRecord()
+	if err == nil || err.Error() != "DNS domain example.org is not found on the account" {
+		t.Errorf("expected a domain not found error, got %v", err)
+	}
+	if created != nil {
+		t.Errorf("expected no record to be created, got %v", created)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ok := func(action string) func(url.Values) string {
+		return func(url.Values) string { return apiData(action, `{}`) }
+	}
+	fail := func(action string, code int, message string) func(url.Values) string {
+		return func(url.Values) string { return apiFailure(action, code, message) }
+	}
+
+	tests := []struct {
+		name         string
+		linodeId     int
+		deleteLinode func(url.Values) string
+		deleteRecord func(url.Values) string
+		actions      []string
+		err          []string
+		resourceId   int
+	}{
+		{
+			name:         "never created",
+			deleteLinode: ok("linode.delete"),
+			deleteRecord: ok("domain.resource.delete"),
+		},
+		{
+			name:         "removed",
+			linodeId:     42,
+			deleteLinode: ok("linode.delete"),
+			deleteRecord: ok("domain.resource.delete"),
+			actions:      []string{"linode.delete", "domain.resource.delete"},
+		},
+		{
+			name:         "already deleted",
+			linodeId:     42,
+			deleteLinode: fail("linode.delete", 5, "Object not found"),
+			deleteRecord: fail("domain.resource.delete", 5, "Object not found"),
+			actions:      []string{"linode.delete", "domain.resource.delete"},
+		},
+		{
+			name:         "DNS record fails",
+			linodeId:     42,
+			deleteLinode: ok("linode.delete"),
+			deleteRecord: fail("domain.resource.delete", 4, "Authentication failed"),
+			actions:      []string{"linode.delete", "domain.resource.delete"},
+			err:          []string{"removing DNS record node1.example.com: Authentication failed"},
+			resourceId:   7,
+		},
+		{
+			name:         "linode fails",
+			linodeId:     42,
+			deleteLinode: fail("linode.delete", 0, "Linode busy"),
+			deleteRecord: ok("domain.resource.delete"),
+			actions:      []string{"linode.delete", "domain.resource.delete"},
+			err:          []string{"Linode API error deleting linode 42: Linode busy"},
+		},
+		{
+			name:         "both fail",
+			linodeId:     42,
+			deleteLinode: fail("linode.delete", 0, "Linode busy"),
+			deleteRecord: fail("domain.resource.delete", 0, "DNS busy"),
+			actions:      []string{"linode.delete", "domain.resource.delete"},
+			err:          []string{"deleting linode 42: Linode busy", "removing DNS record node1.example.com: DNS busy"},
+			resourceId:   7,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &fakeAPI{handlers: map[string]func(url.Values) string{
+				"linode.delete":          test.deleteLinode,
+				"domain.resource.delete": test.deleteRecord,
+			}}
+			d := newTestDriver(api)
+			d.LinodeId = test.linodeId
+			d.DNSDomain = "example.com"
+			d.DNSRecord = "node1"
+			d.DNSDomainId = 5
+			d.DNSResourceId = 7
+
+			err := d.Remove()
+			if len(test.err) == 0 && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			for _, expected := range test.err {
+				if err == nil || !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error %q, got %v", expected, err)
+				}
+			}
+			if actions := api.called(); !reflect.DeepEqual(actions, test.actions) {
+				t.Errorf("expected actions %v, got %v", test.actions, actions)
+			}
+			if test.linodeId != 0 && d.DNSResourceId != test.resourceId {
+				t.Errorf("expected DNS record %d, got %d", test.resourceId, d.DNSResourceId)
+			}
+		})
+	}
+}
+
+func TestSetRDNS(t *testing.T) {
+	tests := []struct {
+		name    string
+		lookups []string
+		set     bool
+		warning string
+	}{
+		{name: "resolves", lookups: []string{"203.0.113.10"}, set: true},
+		{name: "propagating", lookups: []string{"", "203.0.113.10"}, set: true},
+		{
+			name:    "mismatch",
+			lookups: []string{"198.51.100.1"},
+			warning: "Skipping rDNS, node1.example.com resolves to 198.51.100.1 instead of 203.0.113.10",
+		},
+		{
+			name:    "unresolved",
+			lookups: []string{""},
+			warning: "Skipping rDNS, node1.example.com resolves to nothing instead of 203.0.113.10",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLog(t)
+			defer func(lookup func(string) ([]string, error)) { lookupHost = lookup }(lookupHost)
+			lookups := 0
+			lookupHost = func(host string) ([]string, error) {
+				// the last answer is repeated once the lookups run out
+				address := test.lookups[len(test.lookups)-1]
+				if lookups < len(test.lookups) {
+					address = test.lookups[lookups]
+				}
+				lookups++
+				if address == "" {
+					return nil, errors.New("no such host")
+				}
+				return []string{address}, nil
+			}
+			defer func(timeout int) { rdnsTimeout = timeout }(rdnsTimeout)
+			rdnsTimeout = 1
+
+			api := createAPI()
+			api.handlers["linode.ip.setrdns"] = respond("linode.ip.setrdns", `{"IPADDRESSID":5}`)
+			d := newTestDriver(api)
+			d.LinodeId = 42
+			d.IPAddress = "203.0.113.10"
+			d.RDNS = "node1.example.com"
+
+			if err := d.setRDNS(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			requests := api.requests("linode.ip.setrdns")
+			if !test.set {
+				if len(requests) > 0 {
+					t.Errorf("expected the rDNS to be skipped, got %v", requests)
+				}
+				if !strings.Contains(logs.String(), test.warning) {
+					t.Errorf("expected warning %q, got %q", test.warning, logs.String())
+				}
+				return
+			}
+			if len(requests) != 1 || requests[0].Get("IPAddressID") != "5" || requests[0].Get("Hostname") != "node1.example.com" {
+				t.Errorf("expected the rDNS of IP 5 to be set to node1.example.com, got %v", requests)
+			}
+		})
+	}
+}
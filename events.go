@@ -0,0 +1,45 @@
+package linode
+
+import (
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// logJobEvents polls the jobs of linodeId every interval and logs each
+// job when it is queued and when it finishes, until stop is closed
+func (d *Driver) logJobEvents(linodeId int, interval time.Duration, stop <-chan struct{}) {
+	seen := make(map[int]string)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			jobs, err := d.getClient().Job.List(linodeId, -1, false)
+			if err != nil {
+				log.Debugf("Polling linode jobs failed: %s", err)
+				continue
+			}
+
+			for _, job := range jobs.Jobs {
+				status := job.HostSuccess.String()
+				if previous, ok := seen[job.JobId]; ok && previous == status {
+					continue
+				}
+				seen[job.JobId] = status
+
+				switch status {
+				case "":
+					log.Infof("Linode job %d %s: in progress", job.JobId, job.Label)
+				case "1":
+					log.Infof("Linode job %d %s: done", job.JobId, job.Label)
+				default:
+					log.Infof("Linode job %d %s: failed %s", job.JobId, job.Label, job.HostMessage)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package linode
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogJobEvents(t *testing.T) {
+	output := captureLog(t)
+
+	polls := 0
+	api := &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.job.list": func(url.Values) string {
+			polls++
+			if polls == 1 {
+				return apiData("linode.job.list", `[
+					{"JOBID":1,"LABEL":"Create disk","HOST_SUCCESS":""}
+				]`)
+			}
+			return apiData("linode.job.list", `[
+				{"JOBID":1,"LABEL":"Create disk","HOST_SUCCESS":"1"},
+				{"JOBID":2,"LABEL":"Boot","HOST_SUCCESS":"0","HOST_MESSAGE":"no configuration profile"}
+			]`)
+		},
+	}}
+	d := newTestDriver(api)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.logJobEvents(42, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-done
+
+	log := output.String()
+	for _, event := range []string{
+		"Linode job 1 Create disk: in progress",
+		"Linode job 1 Create disk: done",
+		"Linode job 2 Boot: failed no configuration profile",
+	} {
+		if count := strings.Count(log, event); count != 1 {
+			t.Errorf("expected %q to be logged once, got %d times in:\n%s", event, count, log)
+		}
+	}
+}
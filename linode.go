@@ -4,36 +4,118 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnflag"
-	"github.com/docker/machine/libmachine/mcnutils"
 	"github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/state"
 	"github.com/taoh/linodego"
 )
 
+// kernels are the kernel IDs which can be selected by name. GRUB 2 boots
+// the kernel of the distribution, which suits container hosts.
+var kernels = map[string]int{
+	"grub2":       210,
+	"direct-disk": 213,
+	"latest":      138, // latest 64 bit Linode kernel
+}
+
+const (
+	defaultDeployTimeout  = 60
+	defaultBootTimeout    = 360
+	defaultDockerPort     = 2376
+	defaultDataCenterId   = 2
+	defaultPlanId         = 1
+	defaultDistributionId = 140 // Debian 8 (Ubuntu 16.04 LTD = 146)
+
+	totalDiskSize   = 20480
+	defaultSwapSize = 256
+
+	// Cloning, resizing and growing copy or rewrite whole disks, so they are
+	// given the deploy timeout of this many disks
+	copyTimeoutFactor = 10
+)
+
 // Driver is the implementation of BaseDriver interface
 type Driver struct {
 	*drivers.BaseDriver
-	client *linodego.Client
 
-	APIKey     string
-	IPAddress  string
-	DockerPort int
+	// client is created once by getClient and is safe for concurrent use
+	client     *linodego.Client
+	clientOnce sync.Once
+
+	// apiKeyFile is --linode-api-key-file, only the key read from it is stored
+	apiKeyFile string
+
+	APIKey           string
+	APIRate          int
+	IPAddress        string
+	PrivateIPAddress string
+	DockerPort       int
 
 	LinodeId    int
 	LinodeLabel string
+	DiskId      int
+	SwapDiskId  int
+	DataDiskId  int
+	ConfigId    int
 
-	DataCenterId   int
-	PlanId         int
-	PaymentTerm    int
-	RootPassword   string
-	SSHPort        int
-	DistributionId int
-	KernelId       int
+	DNSDomain     string
+	DNSRecord     string
+	DNSDomainId   int
+	DNSResourceId int
+	RDNS          string
+
+	DataCenterId          int
+	PlanId                int
+	PaymentTerm           int
+	RootPassword          string
+	RootPasswordGenerated bool
+	SSHPort               int
+	DistributionId        int
+	KernelId              int
+	Kernel                string
+	NoSSHKey              bool
+	ShutdownWait          int
+	ResizeDisk            bool
+	MaxParallel           int
+	VerboseEvents         bool
+	ImageId               int
+	BootConfig            string
+	CloneFrom             string
+	SwapSize              int
+	SwapSizeOption        string
+	LabelAutoSuffix       bool
+	Adopt                 bool
+	DataDiskSize          int
+	DataDiskFilesystem    string
+	VerifyDockerPort      bool
+	AutoDataCenter        bool
+	CapacityFallback      bool
+	PlanClass             string
+	MinMemory             int
+	MinCores              int
+	ConfigMemoryLimit     int
+	ConfigDevices         string
+	DisallowEOLImage      bool
+	NoRollback            bool
+	PrivateNetworking     bool
+	SSHOverPrivate        bool
+	AlertCPU              int
+	AlertNetworkIn        int
+	AlertNetworkOut       int
+	AlertIO               int
+	NoWatchdog            bool
+	DeployTimeout         int
+	BootTimeout           int
 }
 
 // NewDriver
@@ -48,9 +130,16 @@ func NewDriver(hostName, storePath string) *Driver {
 
 // Get Linode Client
 func (d *Driver) getClient() *linodego.Client {
-	if d.client == nil {
-		d.client = linodego.NewClient(d.APIKey, nil)
-	}
+	d.clientOnce.Do(func() {
+		// Machines stored before --linode-api-rate have no rate, they keep
+		// the default
+		if d.APIRate != 0 {
+			apiLimiter.setRate(d.APIRate)
+		}
+		d.client = linodego.NewClient(d.APIKey, &http.Client{
+			Transport: &rateLimitedTransport{limiter: apiLimiter, base: http.DefaultTransport},
+		})
+	})
 	return d.client
 }
 
@@ -58,13 +147,24 @@ func (d *Driver) DriverName() string {
 	return "linode"
 }
 
+// GetSSHHostname returns the public IP, or the private IP when
+// --linode-ssh-over-private is set and the linode has one
 func (d *Driver) GetSSHHostname() (string, error) {
+	if d.SSHOverPrivate && d.PrivateIPAddress != "" {
+		return d.PrivateIPAddress, nil
+	}
 	return d.GetIP()
 }
 
 // Get IP Address for the Linode. Note that currently the IP Address
-// is cached
+// is cached, it is looked up again when the cached one was lost
 func (d *Driver) GetIP() (string, error) {
+	if d.IPAddress == "" && d.LinodeId != 0 {
+		log.Debugf("IP address is not set, looking it up for linode %d", d.LinodeId)
+		if err := d.lookupIPAddress(); err != nil {
+			return "", fmt.Errorf("IP address is not set: %s", err)
+		}
+	}
 	if d.IPAddress == "" {
 		return "", fmt.Errorf("IP address is not set")
 	}
@@ -79,27 +179,73 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  "",
 			EnvVar: "LINODE_API_KEY",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_API_KEY_FILE",
+			Name:   "linode-api-key-file",
+			Usage:  "File to read the Linode API Key from",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_API_RATE",
+			Name:   "linode-api-rate",
+			Usage:  "Maximum Linode API requests per second of this machine, -1 disables the limit",
+			Value:  defaultAPIRate,
+		},
 		mcnflag.StringFlag{
 			EnvVar: "LINODE_ROOT_PASSWORD",
 			Name:   "linode-root-pass",
-			Usage:  "Root password",
+			Usage:  "Root password, generated when not set unless cloning or adopting a linode",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "LINODE_LABEL",
 			Name:   "linode-label",
 			Usage:  "Linode label",
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_LABEL_AUTO_SUFFIX",
+			Name:   "linode-label-auto-suffix",
+			Usage:  "Append a number to the Linode label when it is already taken",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_ADOPT",
+			Name:   "linode-adopt",
+			Usage:  "Manage the existing linode with --linode-label instead of creating one",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "LINODE_DATACENTER_ID",
 			Name:   "linode-datacenter-id",
 			Usage:  "Linode Data Center Id",
-			Value:  2,
+			Value:  envDefault("LINODE_DEFAULT_DATACENTER_ID", defaultDataCenterId),
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_AUTO_DATACENTER",
+			Name:   "linode-auto-datacenter",
+			Usage:  "Use the data center with the lowest latency, falls back to --linode-datacenter-id",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_CAPACITY_FALLBACK",
+			Name:   "linode-capacity-fallback",
+			Usage:  "Try other data centers in the same country when the data center is out of capacity",
 		},
 		mcnflag.IntFlag{
 			EnvVar: "LINODE_PLAN_ID",
 			Name:   "linode-plan-id",
 			Usage:  "Linode plan id",
-			Value:  1,
+			Value:  envDefault("LINODE_DEFAULT_PLAN_ID", defaultPlanId),
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_PLAN_CLASS",
+			Name:   "linode-plan-class",
+			Usage:  "Use the smallest plan of the class: standard, highmem or dedicated, overrides --linode-plan-id",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_MIN_MEMORY",
+			Name:   "linode-min-memory",
+			Usage:  "Minimum memory in MB of the plan picked by --linode-plan-class",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_MIN_CORES",
+			Name:   "linode-min-cores",
+			Usage:  "Minimum CPU cores of the plan picked by --linode-plan-class",
 		},
 		mcnflag.IntFlag{
 			EnvVar: "LINODE_PAYMENT_TERM",
@@ -117,19 +263,184 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			EnvVar: "LINODE_DISTRIBUTION_ID",
 			Name:   "linode-distribution-id",
 			Usage:  "Linode Distribution Id",
-			Value:  140, // Debian 8 (Ubuntu 16.04 LTD = 146)
+			Value:  envDefault("LINODE_DEFAULT_DISTRIBUTION_ID", defaultDistributionId),
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_DISALLOW_EOL_IMAGE",
+			Name:   "linode-disallow-eol-image",
+			Usage:  "Fail instead of warning when the distribution is end of life",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_IMAGE_ID",
+			Name:   "linode-image-id",
+			Usage:  "Linode private Image Id, deployed instead of the distribution",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_SWAP_SIZE",
+			Name:   "linode-swap-size",
+			Usage:  "Swap disk size in MB, or auto[:ratio] to size it from the plan memory",
+			Value:  strconv.Itoa(defaultSwapSize),
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_DATA_DISK_SIZE",
+			Name:   "linode-data-disk-size",
+			Usage:  "Size in MB of an additional data disk attached as /dev/sdc",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_DATA_DISK_FS",
+			Name:   "linode-data-disk-fs",
+			Usage:  "Filesystem of the data disk: ext4, ext3 or raw",
+			Value:  "ext4",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_CONFIG_MEMORY_LIMIT",
+			Name:   "linode-config-memory-limit",
+			Usage:  "Memory limit in MB of the configuration profile, 0 uses all memory of the plan",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_CONFIG_DEVICES",
+			Name:   "linode-config-devices",
+			Usage:  "Order of the disks as /dev/sda, /dev/sdb, ...: root, swap and data",
 		},
 		mcnflag.IntFlag{
 			EnvVar: "LINODE_KERNEL_ID",
 			Name:   "linode-kernel-id",
 			Usage:  "Linode Kernel Id",
-			Value:  210, // default kernel, GRUB 2,
+			Value:  kernels["grub2"],
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_CLONE_FROM",
+			Name:   "linode-clone-from",
+			Usage:  "ID or label of a linode to clone instead of deploying a distribution",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_DNS_DOMAIN",
+			Name:   "linode-dns-domain",
+			Usage:  "Linode DNS domain to add an A record for the machine to",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_DNS_RECORD",
+			Name:   "linode-dns-record",
+			Usage:  "Name of the A record in --linode-dns-domain",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_RDNS",
+			Name:   "linode-rdns",
+			Usage:  "Reverse DNS name of the public IP, skipped with a warning when it doesn't resolve to the IP",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_BOOT_CONFIG",
+			Name:   "linode-boot-config",
+			Usage:  "Label of the configuration profile to boot",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_PRIVATE_NETWORKING",
+			Name:   "linode-private-networking",
+			Usage:  "Add a private IP address to the linode",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_SSH_OVER_PRIVATE",
+			Name:   "linode-ssh-over-private",
+			Usage:  "Connect with SSH to the private IP address when there is one",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_ALERT_CPU",
+			Name:   "linode-alert-cpu",
+			Usage:  "CPU usage alert threshold in percent, 0 disables the alert",
+			Value:  alertUnset,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_ALERT_NETWORK_IN",
+			Name:   "linode-alert-network-in",
+			Usage:  "Incoming traffic alert threshold in Mb/s, 0 disables the alert",
+			Value:  alertUnset,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_ALERT_NETWORK_OUT",
+			Name:   "linode-alert-network-out",
+			Usage:  "Outgoing traffic alert threshold in Mb/s, 0 disables the alert",
+			Value:  alertUnset,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_ALERT_IO",
+			Name:   "linode-alert-io",
+			Usage:  "Disk IO alert threshold in IO ops/sec, 0 disables the alert",
+			Value:  alertUnset,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_NO_WATCHDOG",
+			Name:   "linode-no-watchdog",
+			Usage:  "Disable the Lassie watchdog which reboots the linode when it crashes",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_KERNEL",
+			Name:   "linode-kernel",
+			Usage:  "Kernel by name: grub2, direct-disk or latest, instead of --linode-kernel-id",
 		},
 		mcnflag.IntFlag{
 			EnvVar: "LINODE_DOCKER_PORT",
 			Name:   "linode-docker-port",
 			Usage:  "Docker Port",
-			Value:  2376,
+			Value:  defaultDockerPort,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_DEPLOY_TIMEOUT",
+			Name:   "linode-deploy-timeout",
+			Usage:  "Seconds to wait for each disk to be deployed, clones and resizes are given 10 times as long",
+			Value:  defaultDeployTimeout,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_BOOT_TIMEOUT",
+			Name:   "linode-boot-timeout",
+			Usage:  "Seconds to wait for the linode to boot and be running",
+			Value:  defaultBootTimeout,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_SHUTDOWN_WAIT",
+			Name:   "linode-shutdown-wait",
+			Usage:  "Seconds to wait for a graceful shutdown on stop",
+			Value:  120,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "LINODE_MAX_PARALLEL",
+			Name:   "linode-max-parallel",
+			Usage:  "Maximum number of disks created in parallel",
+			Value:  3,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_NO_ROLLBACK",
+			Name:   "linode-no-rollback",
+			Usage:  "Keep the linode when create fails after it was created",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_VERBOSE_EVENTS",
+			Name:   "linode-verbose-events",
+			Usage:  "Log the progress of linode jobs during create",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_RESIZE_DISK",
+			Name:   "linode-resize-disk",
+			Usage:  "Grow the primary disk to fill the plan after a resize",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_SSH_USER",
+			Name:   "linode-ssh-user",
+			Usage:  "SSH username, defaults to root, other users need an image, clone or adopted linode which accepts the key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "LINODE_SSH_KEY_PATH",
+			Name:   "linode-ssh-key-path",
+			Usage:  "Path of the SSH private key, defaults to id_rsa in the machine store",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_VERIFY_DOCKER_PORT",
+			Name:   "linode-verify-docker-port",
+			Usage:  "Check the docker port can be reached after create",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "LINODE_NO_SSH_KEY",
+			Name:   "linode-no-ssh-key",
+			Usage:  "Don't generate an SSH key, use the existing key in the machine store",
 		},
 	}
 }
@@ -144,276 +455,1132 @@ func (d *Driver) GetSSHUsername() string {
 
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.APIKey = flags.String("linode-api-key")
+	d.apiKeyFile = flags.String("linode-api-key-file")
 	d.DataCenterId = flags.Int("linode-datacenter-id")
+	d.APIRate = flags.Int("linode-api-rate")
+	d.AutoDataCenter = flags.Bool("linode-auto-datacenter")
+	d.CapacityFallback = flags.Bool("linode-capacity-fallback")
 	d.PlanId = flags.Int("linode-plan-id")
+	d.PlanClass = flags.String("linode-plan-class")
+	d.MinMemory = flags.Int("linode-min-memory")
+	d.MinCores = flags.Int("linode-min-cores")
 	d.PaymentTerm = flags.Int("linode-payment-term")
 	d.RootPassword = flags.String("linode-root-pass")
 	d.SSHPort = flags.Int("linode-ssh-port")
 	d.DistributionId = flags.Int("linode-distribution-id")
 	d.KernelId = flags.Int("linode-kernel-id")
+	d.Kernel = flags.String("linode-kernel")
+	d.DisallowEOLImage = flags.Bool("linode-disallow-eol-image")
+	d.ImageId = flags.Int("linode-image-id")
+	d.BootConfig = flags.String("linode-boot-config")
+	d.CloneFrom = flags.String("linode-clone-from")
+	d.SwapSizeOption = flags.String("linode-swap-size")
+	d.DataDiskSize = flags.Int("linode-data-disk-size")
+	d.DataDiskFilesystem = flags.String("linode-data-disk-fs")
+	d.ConfigMemoryLimit = flags.Int("linode-config-memory-limit")
+	d.ConfigDevices = flags.String("linode-config-devices")
+	d.DNSDomain = flags.String("linode-dns-domain")
+	d.DNSRecord = flags.String("linode-dns-record")
+	d.RDNS = flags.String("linode-rdns")
 	d.LinodeLabel = flags.String("linode-label")
+	d.LabelAutoSuffix = flags.Bool("linode-label-auto-suffix")
+	d.Adopt = flags.Bool("linode-adopt")
+	d.PrivateNetworking = flags.Bool("linode-private-networking")
+	d.SSHOverPrivate = flags.Bool("linode-ssh-over-private")
+	d.AlertCPU = flags.Int("linode-alert-cpu")
+	d.AlertNetworkIn = flags.Int("linode-alert-network-in")
+	d.AlertNetworkOut = flags.Int("linode-alert-network-out")
+	d.AlertIO = flags.Int("linode-alert-io")
+	d.NoWatchdog = flags.Bool("linode-no-watchdog")
 	d.DockerPort = flags.Int("linode-docker-port")
+	d.VerifyDockerPort = flags.Bool("linode-verify-docker-port")
+	d.NoSSHKey = flags.Bool("linode-no-ssh-key")
+	d.SSHKeyPath = flags.String("linode-ssh-key-path")
+	d.SSHUser = flags.String("linode-ssh-user")
+	d.DeployTimeout = flags.Int("linode-deploy-timeout")
+	d.BootTimeout = flags.Int("linode-boot-timeout")
+	d.ShutdownWait = flags.Int("linode-shutdown-wait")
+	d.ResizeDisk = flags.Bool("linode-resize-disk")
+	d.MaxParallel = flags.Int("linode-max-parallel")
+	d.VerboseEvents = flags.Bool("linode-verbose-events")
+	d.NoRollback = flags.Bool("linode-no-rollback")
 
-	if d.APIKey == "" {
-		return fmt.Errorf("linode driver requires the --linode-api-key option")
+	// A cloned or adopted linode keeps the root password it has
+	if d.RootPassword == "" && d.CloneFrom == "" && !d.Adopt {
+		password, err := generateRootPassword()
+		if err != nil {
+			return fmt.Errorf("generating root password: %s", err)
+		}
+		d.RootPassword = password
+		d.RootPasswordGenerated = true
 	}
 
-	if d.RootPassword == "" {
-		return fmt.Errorf("linode driver requires the --linode-root-pass option")
+	if d.ConfigDevices == "" {
+		d.ConfigDevices = "root,swap"
+		if d.DataDiskSize > 0 {
+			d.ConfigDevices += ",data"
+		}
 	}
 
-	return nil
-}
-
-func (d *Driver) PreCreateCheck() error {
-	return nil
+	return d.validate()
 }
 
-func (d *Driver) Create() error {
-	log.Debug("Creating Linode machine instance...")
+// validate checks the options and their combinations, reporting all the
+// problems at once. The API key is read from --linode-api-key-file here, so
+// a file which can't be read is reported with the other problems.
+func (d *Driver) validate() error {
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
 
-	publicKey, err := d.createSSHKey()
-	if err != nil {
-		return err
+	if d.apiKeyFile != "" {
+		if d.APIKey != "" {
+			violate("--linode-api-key (or LINODE_API_KEY) and --linode-api-key-file are ambiguous, set only one of them")
+		} else if err := d.readAPIKeyFile(); err != nil {
+			violate("%s", err)
+		}
+	} else if d.APIKey == "" {
+		violate("linode driver requires the --linode-api-key or --linode-api-key-file option")
+	}
+	if d.APIRate < -1 || d.APIRate == 0 {
+		violate("--linode-api-rate must be at least 1 request per second, or -1 to disable the limit, got %d", d.APIRate)
 	}
 
-	client := d.getClient()
+	if d.CloneFrom != "" && d.RootPassword != "" {
+		violate("--linode-root-pass can't be used with --linode-clone-from, the clone keeps the root password of the source")
+	} else if !d.RootPasswordGenerated && !d.Adopt && d.CloneFrom == "" {
+		if problems := rootPasswordProblems(d.RootPassword); len(problems) > 0 {
+			violate("--linode-root-pass must be %s", strings.Join(problems, " and "))
+		}
+	}
 
-	// Create a linode
-	log.Debug("Creating linode instance")
-	linodeResponse, err := client.Linode.Create(
-		d.DataCenterId,
-		d.PlanId,
-		d.PaymentTerm,
-	)
-	if err != nil {
-		return err
+	var err error
+	if d.SwapSize, err = parseSwapSize(d.SwapSizeOption); err != nil {
+		violate("%s", err)
 	}
 
-	d.LinodeId = linodeResponse.LinodeId.LinodeId
-	log.Debugf("Linode created: %d", d.LinodeId)
+	// Linode only installs the SSH key for root when deploying a disk,
+	// other users have to be set up by the image itself
+	if d.SSHUser != "" && d.SSHUser != "root" && d.ImageId == 0 && d.CloneFrom == "" && !d.Adopt {
+		violate("--linode-ssh-user %s requires --linode-image-id, --linode-clone-from or --linode-adopt, deployed distributions only authorize the SSH key for root", d.SSHUser)
+	}
 
-	if d.LinodeLabel != "" {
-		log.Debugf("Updating linode label to %s", d.LinodeLabel)
-		_, err := client.Linode.Update(d.LinodeId, map[string]interface{}{"Label": d.LinodeLabel})
-		if err != nil {
-			return err
+	sources := 0
+	for _, set := range []bool{d.ImageId != 0, d.CloneFrom != "", d.Adopt} {
+		if set {
+			sources++
 		}
 	}
+	if sources > 1 {
+		violate("--linode-image-id, --linode-clone-from and --linode-adopt are mutually exclusive")
+	}
 
-	linodeIPListResponse, err := client.Ip.List(d.LinodeId, -1)
-	if err != nil {
-		return err
+	if d.Adopt && d.LinodeLabel == "" {
+		violate("--linode-adopt requires the --linode-label of the linode to adopt")
 	}
-	for _, fullIpAddress := range linodeIPListResponse.FullIPAddresses {
-		if fullIpAddress.IsPublic == 1 {
-			d.IPAddress = fullIpAddress.IPAddress
-		}
+	if d.LabelAutoSuffix && d.LinodeLabel == "" {
+		violate("--linode-label-auto-suffix requires --linode-label")
+	}
+	if d.LabelAutoSuffix && d.Adopt {
+		violate("--linode-label-auto-suffix can't be used with --linode-adopt")
 	}
 
-	if d.IPAddress == "" {
-		return errors.New("Linode IP Address is not found.")
+	if (d.DNSDomain == "") != (d.DNSRecord == "") {
+		violate("--linode-dns-domain and --linode-dns-record must be set together")
 	}
 
-	log.Debugf("Created linode ID %d, IP address %s",
-		d.LinodeId,
-		d.IPAddress)
+	if d.DataDiskSize < 0 {
+		violate("--linode-data-disk-size must not be negative, got %d", d.DataDiskSize)
+	}
+	if d.DataDiskSize > 0 && (d.CloneFrom != "" || d.Adopt) {
+		violate("--linode-data-disk-size can't be used with --linode-clone-from or --linode-adopt")
+	}
 
-	// Deploy distribution
-	args := make(map[string]string)
-	args["rootPass"] = d.RootPassword
-	args["rootSSHKey"] = publicKey
-	distributionId := d.DistributionId
+	if err := validateConfigDevices(d.ConfigDevices, d.DataDiskSize > 0); err != nil {
+		violate("%s", err)
+	}
+	if d.ConfigMemoryLimit < 0 {
+		violate("--linode-config-memory-limit must not be negative, got %d", d.ConfigMemoryLimit)
+	}
 
-	log.Debug("Create disk")
-	createDiskJobResponse, err := d.client.Disk.CreateFromDistribution(distributionId, d.LinodeId, "Primary Disk", 20480-256, args)
+	switch d.DataDiskFilesystem {
+	case "ext4", "ext3", "raw":
+	default:
+		violate("--linode-data-disk-fs must be ext4, ext3 or raw, got %q", d.DataDiskFilesystem)
+	}
 
-	if err != nil {
-		return err
+	for flag, threshold := range map[string]int{
+		"--linode-alert-cpu":         d.AlertCPU,
+		"--linode-alert-network-in":  d.AlertNetworkIn,
+		"--linode-alert-network-out": d.AlertNetworkOut,
+		"--linode-alert-io":          d.AlertIO,
+	} {
+		if threshold < alertUnset {
+			violate("%s must not be negative, got %d", flag, threshold)
+		}
 	}
 
-	jobId := createDiskJobResponse.DiskJob.JobId
-	diskId := createDiskJobResponse.DiskJob.DiskId
-	log.Debugf("Linode create disk task :%d.", jobId)
+	if d.DeployTimeout < 1 {
+		violate("--linode-deploy-timeout must be at least 1 second, got %d", d.DeployTimeout)
+	}
+	if d.BootTimeout < 1 {
+		violate("--linode-boot-timeout must be at least 1 second, got %d", d.BootTimeout)
+	}
 
-	// wait until the creation is finished
-	err = d.waitForJob(jobId, "Create Disk Task", 60)
-	if err != nil {
-		return err
+	// The kernel name sets KernelId, which must still be the flag default
+	if d.Kernel != "" {
+		kernelId, ok := kernels[d.Kernel]
+		switch {
+		case !ok:
+			violate("--linode-kernel must be grub2, direct-disk or latest, got %q", d.Kernel)
+		case d.KernelId != kernels["grub2"] && d.KernelId != kernelId:
+			violate("--linode-kernel %s and --linode-kernel-id %d are ambiguous, set only one of them", d.Kernel, d.KernelId)
+		default:
+			d.KernelId = kernelId
+		}
 	}
 
-	// create swap
-	log.Debug("Create swap disk")
-	createDiskJobResponse, err = d.client.Disk.Create(d.LinodeId, "swap", "Swap Disk", 256, nil)
-	if err != nil {
-		return err
+	// Deployed distributions have no boot loader of their own
+	if d.KernelId == kernels["direct-disk"] && d.ImageId == 0 && d.CloneFrom == "" && !d.Adopt {
+		violate("the direct-disk kernel needs a disk with a boot loader, use it with --linode-image-id or --linode-clone-from")
 	}
 
-	jobId = createDiskJobResponse.DiskJob.JobId
-	swapDiskId := createDiskJobResponse.DiskJob.DiskId
-	log.Debugf("Linode create swap disk task :%d.", jobId)
+	if _, ok := planClasses[d.PlanClass]; d.PlanClass != "" && !ok {
+		violate("--linode-plan-class must be standard, highmem or dedicated, got %q", d.PlanClass)
+	}
+	if (d.MinMemory != 0 || d.MinCores != 0) && d.PlanClass == "" {
+		violate("--linode-min-memory and --linode-min-cores require --linode-plan-class")
+	}
 
-	// wait until the creation is finished
-	err = d.waitForJob(jobId, "Create Swap Disk Task", 60)
-	if err != nil {
-		return err
+	if d.MaxParallel < 1 {
+		violate("--linode-max-parallel must be at least 1, got %d", d.MaxParallel)
 	}
 
-	// create config
-	log.Debug("Create configuration")
-	args2 := make(map[string]string)
-	args2["DiskList"] = fmt.Sprintf("%d,%d", diskId, swapDiskId)
-	args2["RootDeviceNum"] = "1"
-	args2["RootDeviceRO"] = "true"
-	args2["helper_distro"] = "true"
-	kernelId := d.KernelId
-	_, err = d.client.Config.Create(d.LinodeId, kernelId, "My Docker Machine Configuration", args2)
+	if d.DockerPort < 1 || d.DockerPort > 65535 {
+		violate("--linode-docker-port must be between 1 and 65535, got %d", d.DockerPort)
+	}
 
-	if err != nil {
-		return err
+	if len(violations) > 0 {
+		return fmt.Errorf("invalid linode driver options:\n  %s", strings.Join(violations, "\n  "))
 	}
+	return nil
+}
 
-	log.Debugf("Linode configuration created.")
+func (d *Driver) PreCreateCheck() error {
+	if d.AutoDataCenter {
+		d.selectFastestDataCenter()
+	}
 
-	// Boot
-	log.Debug("Booting")
-	jobResponse, err := d.client.Linode.Boot(d.LinodeId, -1)
-	if err != nil {
-		return err
+	if d.PlanClass != "" {
+		if err := d.resolvePlanClass(); err != nil {
+			return err
+		}
 	}
-	jobId = jobResponse.JobId.JobId
-	log.Debugf("Booting linode, job id: %v", jobId)
-	// wait for boot
-	err = d.waitForJob(jobId, "Booting linode", 60)
-	if err != nil {
+
+	if err := d.resolveSwapSize(); err != nil {
 		return err
 	}
 
-	log.Debug("Waiting for Machine Running...")
-	if err := mcnutils.WaitForSpecific(drivers.MachineInState(d, state.Running), 120, 3*time.Second); err != nil {
-		return fmt.Errorf("wait for machine running failed: %s", err)
+	if d.LinodeLabel != "" && !d.Adopt {
+		if err := d.checkLabel(); err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
+	// Restricted users need grants for the optional features, check them
+	// before the linode exists rather than failing halfway through create
+	if d.CloneFrom != "" {
+		if _, err := d.findLinode(d.CloneFrom); err != nil {
+			return permissionError("--linode-clone-from", "read/write access to the source linode", err)
+		}
+	}
 
-func (d *Driver) GetURL() (string, error) {
-	ip, err := d.GetIP()
-	if err != nil {
-		return "", err
+	if d.DNSDomain != "" {
+		if _, err := d.findDomain(d.DNSDomain); err != nil {
+			return permissionError("--linode-dns-domain", "the DNS manager grant", err)
+		}
 	}
-	if ip == "" {
-		return "", nil
+
+	if d.DataDiskSize > 0 {
+		if err := d.checkDataDiskSize(); err != nil {
+			return err
+		}
+	}
+
+	if d.ConfigMemoryLimit > 0 {
+		if err := d.checkConfigMemoryLimit(); err != nil {
+			return err
+		}
+	}
+
+	if d.ImageId != 0 {
+		if err := d.checkImage(); err != nil {
+			return permissionError("--linode-image-id", "access to the image", err)
+		}
+	} else if d.CloneFrom == "" && !d.Adopt {
+		if err := d.checkDistribution(); err != nil {
+			return err
+		}
 	}
 
-	return fmt.Sprintf("tcp://%s:%d", ip, d.DockerPort), nil
+	return nil
 }
 
-func (d *Driver) GetState() (state.State, error) {
-	linodes, err := d.getClient().Linode.List(d.LinodeId)
+// checkImage verifies the private image is available to the API key and fits
+// on the primary disk
+func (d *Driver) checkImage() error {
+	images, err := d.getClient().Image.List(false, d.ImageId)
 	if err != nil {
-		return state.Error, err
+		return fmt.Errorf("looking up image %d: %s", d.ImageId, err)
 	}
 
-	// Status flag values:
-	// -2: Boot Failed
-	// -1: Being Created
-	//  0: Brand New
-	//  1: Running
-	//  2: Powered Off
-	//  3: Shutting Down
-	//  4: Saved to Disk
-	//
-	switch linodes.Linodes[0].Status {
-	case -1, 0:
-		return state.Starting, nil
-	case 1:
-		return state.Running, nil
-	case -2, 2, 4:
-		return state.Stopped, nil
-	case 3:
-		return state.Stopping, nil
+	for _, image := range images.Images {
+		if image.ImageId != d.ImageId {
+			continue
+		}
+		if image.Status != "available" {
+			return fmt.Errorf("image %d (%s) is %s, not available", d.ImageId, image.Label, image.Status)
+		}
+		if image.MinSize > d.primaryDiskSize() {
+			return fmt.Errorf("image %d (%s) needs %dMB, more than the %dMB primary disk", d.ImageId, image.Label, image.MinSize, d.primaryDiskSize())
+		}
+		return nil
 	}
-	return state.None, nil
-}
 
-func (d *Driver) Start() error {
-	log.Debug("Start...")
-	_, err := d.getClient().Linode.Boot(d.LinodeId, -1)
-	return err
+	return fmt.Errorf("image %d is not found or not accessible with this API key", d.ImageId)
 }
 
-func (d *Driver) Stop() error {
-	log.Debug("Stop...")
-	_, err := d.getClient().Linode.Shutdown(d.LinodeId)
-	return err
-}
+func (d *Driver) Create() error {
+	log.Debug("Creating Linode machine instance...")
 
-func (d *Driver) Remove() error {
-	client := d.getClient()
-	log.Debugf("Removing linode: %d", d.LinodeId)
-	if _, err := client.Linode.Delete(d.LinodeId, true); err != nil {
+	var publicKey string
+	var err error
+	if d.NoSSHKey {
+		log.Debugf("Skipping SSH key generation, using existing key %s", d.publicSSHKeyPath())
+		publicKey, err = d.readPublicSSHKey()
+	} else {
+		publicKey, err = d.createSSHKey()
+	}
+	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func (d *Driver) Restart() error {
-	log.Debug("Restarting...")
-	_, err := d.getClient().Linode.Reboot(d.LinodeId, -1)
-	return err
-}
-
-func (d *Driver) Kill() error {
-	log.Debug("Killing...")
-	_, err := d.getClient().Linode.Shutdown(d.LinodeId)
-	return err
-}
 
-func (d *Driver) createSSHKey() (string, error) {
-	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
-		return "", err
+	// An adopted linode keeps its disks and root password
+	if d.Adopt {
+		return d.adopt()
 	}
 
-	publicKey, err := ioutil.ReadFile(d.publicSSHKeyPath())
-	if err != nil {
-		return "", err
+	if d.RootPasswordGenerated {
+		if err := d.saveRootPassword(); err != nil {
+			return err
+		}
+		log.Infof("Generated root password saved to %s", d.ResolveStorePath(rootPasswordFile))
 	}
 
-	return string(publicKey), nil
-}
+	client := d.getClient()
 
-// waitForJob checks job status every 1 second until timeout
+	var linodeResponse *linodego.LinodeResponse
+	if d.CloneFrom != "" {
+		sourceId, err := d.findLinode(d.CloneFrom)
+		if err != nil {
+			return err
+		}
+
+		// Cloned disks only authorize the SSH keys of the source
+		log.Infof("Cloning linode %d, its SSH keys are kept so it must accept %s", sourceId, d.publicSSHKeyPath())
+
+		log.Debugf("Cloning linode %d", sourceId)
+		linodeResponse, err = client.Linode.Clone(sourceId, d.DataCenterId, d.PlanId, d.PaymentTerm)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Create a linode
+		log.Debug("Creating linode instance")
+		linodeResponse, err = d.createLinode()
+		if err != nil {
+			return err
+		}
+	}
+
+	d.LinodeId = linodeResponse.LinodeId.LinodeId
+	log.Debugf("Linode created: %d", d.LinodeId)
+
+	if d.VerboseEvents {
+		stop := make(chan struct{})
+		defer close(stop)
+		go d.logJobEvents(d.LinodeId, 3*time.Second, stop)
+	}
+
+	if err := d.setupLinode(publicKey); err != nil {
+		if !d.NoRollback {
+			d.rollback()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// setupLinode configures, deploys and boots the created linode
+func (d *Driver) setupLinode(publicKey string) error {
+	client := d.getClient()
+
+	updates := d.alertUpdates()
+	updates["watchdog"] = !d.NoWatchdog
+	if d.LinodeLabel != "" {
+		log.Debugf("Updating linode label to %s", d.LinodeLabel)
+		updates["Label"] = d.LinodeLabel
+	}
+	if _, err := client.Linode.Update(d.LinodeId, updates); err != nil {
+		return err
+	}
+
+	if err := d.lookupIPAddress(); err != nil {
+		return err
+	}
+
+	log.Debugf("Created linode ID %d, IP address %s",
+		d.LinodeId,
+		d.IPAddress)
+
+	if d.CloneFrom != "" {
+		// The clone has the disks and configuration profiles of the source
+		if err := d.waitForPendingJobs("Clone linode", d.deployTimeout()*copyTimeoutFactor); err != nil {
+			return err
+		}
+		if d.BootConfig == "" {
+			configs, err := client.Config.List(d.LinodeId, -1)
+			if err != nil {
+				return err
+			}
+			if len(configs.LinodeConfigs) == 0 {
+				return fmt.Errorf("cloned linode %d has no configuration profile", d.LinodeId)
+			}
+			d.ConfigId = configs.LinodeConfigs[0].ConfigId
+		}
+	} else if err := d.deployDisks(publicKey); err != nil {
+		return err
+	}
+
+	if d.BootConfig != "" {
+		var err error
+		if d.ConfigId, err = d.findConfig(d.BootConfig); err != nil {
+			return err
+		}
+	}
+
+	// The private IP is configured by the network helper on boot
+	if d.PrivateNetworking && d.PrivateIPAddress == "" {
+		log.Debug("Adding private IP address")
+		ipResponse, err := client.Ip.AddPrivate(d.LinodeId)
+		if err != nil {
+			return err
+		}
+		d.PrivateIPAddress = ipResponse.IPAddress.IPAddress
+		log.Debugf("Private IP address %s added", d.PrivateIPAddress)
+	}
+
+	// Boot
+	log.Debug("Booting")
+	jobResponse, err := client.Linode.Boot(d.LinodeId, d.bootConfigId())
+	if err != nil {
+		return err
+	}
+	jobId := jobResponse.JobId.JobId
+	log.Debugf("Booting linode, job id: %v", jobId)
+	// wait for boot
+	err = d.waitForJob(jobId, "Booting linode", d.bootTimeout())
+	if err != nil {
+		return err
+	}
+
+	log.Debug("Waiting for Machine Running...")
+	if err := d.waitForState(state.Running, d.bootTimeout()); err != nil {
+		return fmt.Errorf("wait for machine running failed: %s", err)
+	}
+
+	if d.DNSDomain != "" {
+		if err := d.createDNSRecord(); err != nil {
+			return err
+		}
+	}
+
+	if d.RDNS != "" {
+		if err := d.setRDNS(); err != nil {
+			return err
+		}
+	}
+
+	if d.VerifyDockerPort {
+		d.verifyDockerPort(10 * time.Second)
+	}
+
+	return nil
+}
+
+// rollback deletes the linode and the DNS record after a failed create, so
+// no half configured linode is left behind
+func (d *Driver) rollback() {
+	log.Infof("Create failed, deleting linode %d", d.LinodeId)
+	if err := d.Remove(); err != nil {
+		log.Warnf("Rolling back linode %d failed, remove what is left manually: %s", d.LinodeId, err)
+		return
+	}
+	d.LinodeId = 0
+	d.IPAddress = ""
+	d.PrivateIPAddress = ""
+}
+
+// deployDisks creates the primary and swap disks from the distribution or
+// image, and the configuration profile booting them
+func (d *Driver) deployDisks(publicKey string) error {
+	client := d.getClient()
+
+	args := make(map[string]string)
+	args["rootPass"] = d.RootPassword
+	args["rootSSHKey"] = publicKey
+	distributionId := d.DistributionId
+
+	disks := []diskTask{
+		{
+			name: "Primary Disk",
+			create: func() (*linodego.LinodeDiskJobResponse, error) {
+				if d.ImageId != 0 {
+					return client.Disk.CreateFromImage(d.ImageId, d.LinodeId, "Primary Disk", d.primaryDiskSize(), args)
+				}
+				return client.Disk.CreateFromDistribution(distributionId, d.LinodeId, "Primary Disk", d.primaryDiskSize(), args)
+			},
+			diskId: &d.DiskId,
+		},
+		{
+			name: "Swap Disk",
+			create: func() (*linodego.LinodeDiskJobResponse, error) {
+				return client.Disk.Create(d.LinodeId, "swap", "Swap Disk", d.SwapSize, nil)
+			},
+			diskId: &d.SwapDiskId,
+		},
+	}
+	if d.DataDiskSize > 0 {
+		disks = append(disks, diskTask{
+			name: "Data Disk",
+			create: func() (*linodego.LinodeDiskJobResponse, error) {
+				return client.Disk.Create(d.LinodeId, d.DataDiskFilesystem, "Data Disk", d.DataDiskSize, nil)
+			},
+			diskId: &d.DataDiskId,
+		})
+	}
+
+	if err := d.createDisks(disks); err != nil {
+		return err
+	}
+
+	// create config
+	log.Debug("Create configuration")
+	diskList, rootDevice, err := d.configDevices()
+	if err != nil {
+		return err
+	}
+
+	args2 := make(map[string]string)
+	args2["DiskList"] = diskList
+	args2["RootDeviceNum"] = strconv.Itoa(rootDevice)
+	if d.ConfigMemoryLimit > 0 {
+		args2["RAMLimit"] = strconv.Itoa(d.ConfigMemoryLimit)
+	}
+	args2["RootDeviceRO"] = "true"
+	args2["helper_distro"] = "true"
+	kernelId := d.KernelId
+	configResponse, err := client.Config.Create(d.LinodeId, kernelId, "My Docker Machine Configuration", args2)
+
+	if err != nil {
+		return err
+	}
+
+	d.ConfigId = configResponse.LinodeConfigId.LinodeConfigId
+	log.Debugf("Linode configuration created.")
+	return nil
+}
+
+func (d *Driver) GetURL() (string, error) {
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+	if ip == "" {
+		return "", nil
+	}
+
+	// Machines stored before the docker port was configurable have no
+	// port set, fall back to the default rather than returning port 0
+	port := d.DockerPort
+	if port == 0 {
+		port = defaultDockerPort
+	}
+
+	return fmt.Sprintf("tcp://%s:%d", ip, port), nil
+}
+
+// GetPrivateURL returns the docker URL on the private IP address, for swarm
+// and cluster traffic between linodes in the same data center
+func (d *Driver) GetPrivateURL() (string, error) {
+	if d.PrivateIPAddress == "" {
+		return "", fmt.Errorf("private IP address is not set, create the machine with --linode-private-networking")
+	}
+
+	port := d.DockerPort
+	if port == 0 {
+		port = defaultDockerPort
+	}
+
+	return fmt.Sprintf("tcp://%s:%d", d.PrivateIPAddress, port), nil
+}
+
+func (d *Driver) GetState() (state.State, error) {
+	linodes, err := d.getClient().Linode.List(d.LinodeId)
+	if err != nil {
+		return state.Error, err
+	}
+
+	// Status flag values:
+	// -2: Boot Failed
+	// -1: Being Created
+	//  0: Brand New
+	//  1: Running
+	//  2: Powered Off
+	//  3: Shutting Down
+	//  4: Saved to Disk
+	//
+	if len(linodes.Linodes) == 0 {
+		return state.Error, fmt.Errorf("Linode %d is not found.", d.LinodeId)
+	}
+
+	linode := linodes.Linodes[0]
+	log.Debugf("Linode %d %s: status %d, plan %d, data center %d, created %s, IP address %s",
+		linode.LinodeId, linode.Label, linode.Status, linode.PlanId, linode.DataCenterId, linode.CreateDt, d.IPAddress)
+
+	switch linode.Status {
+	case -1, 0:
+		return state.Starting, nil
+	case 1:
+		return state.Running, nil
+	case -2, 2, 4:
+		return state.Stopped, nil
+	case 3:
+		return state.Stopping, nil
+	}
+	return state.None, nil
+}
+
+func (d *Driver) Start() error {
+	log.Debug("Start...")
+	_, err := d.getClient().Linode.Boot(d.LinodeId, d.bootConfigId())
+	return d.apiError("booting", err)
+}
+
+// Stop requests a graceful shutdown and waits until the linode is powered off
+func (d *Driver) Stop() error {
+	log.Debug("Stop...")
+	jobResponse, err := d.getClient().Linode.Shutdown(d.LinodeId)
+	if err != nil {
+		return d.apiError("shutting down", err)
+	}
+
+	shutdownWait := d.ShutdownWait
+	if shutdownWait <= 0 {
+		shutdownWait = 120
+	}
+	return d.waitForJob(jobResponse.JobId.JobId, "Shutting down linode", shutdownWait)
+}
+
+// Remove deletes the linode and its DNS record. Both are attempted even when
+// the other fails, so a failed create can always be rolled back.
+func (d *Driver) Remove() error {
+	if d.LinodeId == 0 {
+		log.Debug("Linode was never created, nothing to remove")
+		return nil
+	}
+
+	var errs []string
+
+	client := d.getClient()
+	log.Debugf("Removing linode: %d", d.LinodeId)
+	if _, err := client.Linode.Delete(d.LinodeId, true); err != nil {
+		if isNotFound(err) {
+			log.Warnf("Linode %d is already deleted", d.LinodeId)
+		} else {
+			errs = append(errs, d.apiError("deleting", err).Error())
+		}
+	}
+
+	if err := d.removeDNSRecord(); err != nil {
+		errs = append(errs, fmt.Sprintf("removing DNS record %s.%s: %s", d.DNSRecord, d.DNSDomain, err))
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Restart reboots the linode and waits until it is running again
+func (d *Driver) Restart() error {
+	log.Debug("Restarting...")
+	jobResponse, err := d.getClient().Linode.Reboot(d.LinodeId, d.bootConfigId())
+	if err != nil {
+		return d.apiError("rebooting", err)
+	}
+
+	if err := d.waitForJob(jobResponse.JobId.JobId, "Rebooting linode", d.bootTimeout()); err != nil {
+		return err
+	}
+	if err := d.waitForState(state.Running, d.bootTimeout()); err != nil {
+		return err
+	}
+
+	// The IP doesn't change on reboot, verify it anyway
+	previous := d.IPAddress
+	if err := d.lookupIPAddress(); err != nil {
+		return err
+	}
+	if d.IPAddress != previous {
+		log.Warnf("IP address of linode %d changed from %s to %s", d.LinodeId, previous, d.IPAddress)
+	}
+	return nil
+}
+
+// Kill powers off the linode without waiting for the shutdown to complete
+func (d *Driver) Kill() error {
+	log.Debug("Killing...")
+	_, err := d.getClient().Linode.Shutdown(d.LinodeId)
+	return d.apiError("shutting down", err)
+}
+
+// Resize moves the linode to another plan. When --linode-resize-disk was set
+// the primary disk is grown afterwards to fill the space of the new plan.
+func (d *Driver) Resize(planId int) error {
+	client := d.getClient()
+
+	log.Debugf("Resizing linode %d to plan %d", d.LinodeId, planId)
+	if _, err := client.Linode.Resize(d.LinodeId, planId); err != nil {
+		return err
+	}
+
+	if err := d.waitForPendingJobs("Resize linode", d.deployTimeout()*copyTimeoutFactor); err != nil {
+		return err
+	}
+	d.PlanId = planId
+
+	if !d.ResizeDisk {
+		return nil
+	}
+
+	return d.growPrimaryDisk()
+}
+
+// growPrimaryDisk resizes the primary disk to all the space left by the
+// other disks of the linode
+func (d *Driver) growPrimaryDisk() error {
+	client := d.getClient()
+
+	linodes, err := client.Linode.List(d.LinodeId)
+	if err != nil {
+		return err
+	}
+	if len(linodes.Linodes) == 0 {
+		return fmt.Errorf("Linode %d is not found.", d.LinodeId)
+	}
+
+	disks, err := client.Disk.List(d.LinodeId, -1)
+	if err != nil {
+		return err
+	}
+
+	size := linodes.Linodes[0].TotalHD
+	current := 0
+	for _, disk := range disks.Disks {
+		if disk.DiskId == d.DiskId {
+			current = disk.Size
+			continue
+		}
+		size -= disk.Size
+	}
+
+	if current == 0 {
+		return fmt.Errorf("Primary disk %d is not found.", d.DiskId)
+	}
+	if size <= current {
+		log.Debugf("Primary disk is already %dMB, not resizing", current)
+		return nil
+	}
+
+	log.Debugf("Resizing primary disk from %dMB to %dMB", current, size)
+	diskJobResponse, err := client.Disk.Resize(d.LinodeId, d.DiskId, size)
+	if err != nil {
+		return err
+	}
+
+	return d.waitForJob(diskJobResponse.DiskJob.JobId, "Resize Disk Task", d.deployTimeout()*copyTimeoutFactor)
+}
+
+// deployTimeout returns the deploy timeout, machines created before it was
+// configurable have none stored
+func (d *Driver) deployTimeout() int {
+	if d.DeployTimeout <= 0 {
+		return defaultDeployTimeout
+	}
+	return d.DeployTimeout
+}
+
+// bootTimeout returns the boot timeout, machines created before it was
+// configurable have none stored
+func (d *Driver) bootTimeout() int {
+	if d.BootTimeout <= 0 {
+		return defaultBootTimeout
+	}
+	return d.BootTimeout
+}
+
+// findLinode returns the ID of the linode with the given ID or label
+func (d *Driver) findLinode(idOrLabel string) (int, error) {
+	linodes, err := d.getClient().Linode.List(-1)
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := strconv.Atoi(idOrLabel)
+	for _, linode := range linodes.Linodes {
+		if linode.LinodeId == id || linode.Label == idOrLabel {
+			return linode.LinodeId, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Linode %s is not found.", idOrLabel)
+}
+
+// lookupIPAddress sets IPAddress to the public IP of the linode, and
+// PrivateIPAddress to its private IP if it has one
+func (d *Driver) lookupIPAddress() error {
+	linodeIPListResponse, err := d.getClient().Ip.List(d.LinodeId, -1)
+	if err != nil {
+		return err
+	}
+	for _, fullIpAddress := range linodeIPListResponse.FullIPAddresses {
+		if fullIpAddress.IsPublic == 1 && !privateIP(fullIpAddress.IPAddress) {
+			d.IPAddress = fullIpAddress.IPAddress
+		}
+		if fullIpAddress.IsPublic == 0 {
+			d.PrivateIPAddress = fullIpAddress.IPAddress
+		}
+	}
+
+	if d.IPAddress == "" {
+		return errors.New("Linode IP Address is not found.")
+	}
+	return nil
+}
+
+// adopt takes over the existing linode with the configured label instead of
+// creating one
+func (d *Driver) adopt() error {
+	linodeId, err := d.findLinode(d.LinodeLabel)
+	if err != nil {
+		return err
+	}
+
+	d.LinodeId = linodeId
+	if err := d.lookupIPAddress(); err != nil {
+		return err
+	}
+	log.Infof("Adopted linode ID %d, IP address %s, it must accept %s", d.LinodeId, d.IPAddress, d.publicSSHKeyPath())
+
+	if d.BootConfig != "" {
+		if d.ConfigId, err = d.findConfig(d.BootConfig); err != nil {
+			return err
+		}
+	}
+
+	current, err := d.GetState()
+	if err != nil {
+		return err
+	}
+	if current == state.Running {
+		return nil
+	}
+
+	log.Debug("Booting adopted linode")
+	jobResponse, err := d.getClient().Linode.Boot(d.LinodeId, d.bootConfigId())
+	if err != nil {
+		return err
+	}
+	if err := d.waitForJob(jobResponse.JobId.JobId, "Booting linode", d.bootTimeout()); err != nil {
+		return err
+	}
+
+	return d.waitForState(state.Running, d.bootTimeout())
+}
+
+// checkLabel fails when another linode already uses the label, or picks the
+// first free "<label>-<n>" with --linode-label-auto-suffix
+func (d *Driver) checkLabel() error {
+	linodes, err := d.getClient().Linode.List(-1)
+	if err != nil {
+		return err
+	}
+
+	labels := make(map[string]bool, len(linodes.Linodes))
+	for _, linode := range linodes.Linodes {
+		labels[linode.Label] = true
+	}
+
+	if !labels[d.LinodeLabel] {
+		return nil
+	}
+	if !d.LabelAutoSuffix {
+		return fmt.Errorf("Linode label %s is already in use, choose another --linode-label or set --linode-label-auto-suffix", d.LinodeLabel)
+	}
+
+	for n := 1; ; n++ {
+		label := fmt.Sprintf("%s-%d", d.LinodeLabel, n)
+		if !labels[label] {
+			log.Infof("Linode label %s is already in use, using %s", d.LinodeLabel, label)
+			d.LinodeLabel = label
+			return nil
+		}
+	}
+}
+
+// findConfig returns the ID of the configuration profile of the linode with
+// the given label
+func (d *Driver) findConfig(label string) (int, error) {
+	configs, err := d.getClient().Config.List(d.LinodeId, -1)
+	if err != nil {
+		return 0, err
+	}
+
+	labels := make([]string, 0, len(configs.LinodeConfigs))
+	for _, config := range configs.LinodeConfigs {
+		if config.Label == label {
+			return config.ConfigId, nil
+		}
+		labels = append(labels, config.Label)
+	}
+
+	return 0, fmt.Errorf("configuration profile %q is not found on linode %d, available: %s",
+		label, d.LinodeId, strings.Join(labels, ", "))
+}
+
+// bootConfigId returns the configuration profile to boot, -1 lets Linode
+// pick the last booted one for machines created before it was recorded
+func (d *Driver) bootConfigId() int {
+	if d.ConfigId == 0 {
+		return -1
+	}
+	return d.ConfigId
+}
+
+// generateSSHKey is replaceable to generate the SSH key pair of the machine
+var generateSSHKey = ssh.GenerateSSHKey
+
+// createSSHKey generates the SSH key unless it already exists and returns the
+// public key. Key files written by a failed attempt are removed again.
+func (d *Driver) createSSHKey() (string, error) {
+	keyPath := d.GetSSHKeyPath()
+	if _, err := os.Stat(keyPath); err == nil {
+		return d.readPublicSSHKey()
+	}
+
+	if err := generateSSHKey(keyPath); err != nil {
+		d.removeSSHKey()
+		return "", fmt.Errorf("generating SSH key %s: %s", keyPath, err)
+	}
+
+	publicKey, err := d.readPublicSSHKey()
+	if err != nil {
+		d.removeSSHKey()
+		return "", fmt.Errorf("reading generated SSH key: %s", err)
+	}
+
+	return publicKey, nil
+}
+
+// removeSSHKey deletes the key files of the machine
+func (d *Driver) removeSSHKey() {
+	for _, path := range []string{d.GetSSHKeyPath(), d.publicSSHKeyPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove SSH key file %s: %s", path, err)
+		}
+	}
+}
+
+// readPublicSSHKey reads the public key of the machine from the store
+func (d *Driver) readPublicSSHKey() (string, error) {
+	publicKey, err := ioutil.ReadFile(d.publicSSHKeyPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("SSH public key %s does not exist", d.publicSSHKeyPath())
+		}
+		return "", err
+	}
+
+	return string(publicKey), nil
+}
+
+// waitForJob checks job status with a growing interval until timeout
 func (d *Driver) waitForJob(jobId int, jobName string, timeOutSeconds int) error {
 	log.Debugf("Wait for job %s completion...", jobName)
-	timeout := time.After(time.Duration(timeOutSeconds) * time.Second)
-	tick := time.Tick(1000 * time.Millisecond)
-	for {
-		select {
-		case <-timeout:
-			return fmt.Errorf("Job %s timed out after %d seconds.", jobName, timeOutSeconds)
-		case <-tick:
-			{
-				clientJobResponse, err := d.getClient().Job.List(d.LinodeId, jobId, false)
-				if err != nil {
-					return err
-				}
+	return waitFor("Job "+jobName, timeOutSeconds, func() (bool, error) {
+		clientJobResponse, err := d.getClient().Job.List(d.LinodeId, jobId, false)
+		if err != nil {
+			return false, err
+		}
 
-				if len(clientJobResponse.Jobs) < 0 || clientJobResponse.Jobs[0].JobId != jobId {
-					return fmt.Errorf("Job %s is not found.", jobName)
-				}
+		if len(clientJobResponse.Jobs) == 0 || clientJobResponse.Jobs[0].JobId != jobId {
+			return false, fmt.Errorf("Job %s is not found.", jobName)
+		}
 
-				if clientJobResponse.Jobs[0].HostSuccess.String() == "1" {
-					log.Debugf("Linode job %s completed.", jobName)
-					return nil
-				}
-				// if not success, wait for next check
-			}
+		if clientJobResponse.Jobs[0].HostSuccess.String() == "1" {
+			log.Debugf("Linode job %s completed.", jobName)
+			return true, nil
 		}
+		// if not success, wait for next check
+		return false, nil
+	})
+}
+
+// apiError adds the action and the linode to an error returned by the API,
+// which otherwise only carries the API error message
+func (d *Driver) apiError(action string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("Linode API error %s linode %d: %s", action, d.LinodeId, err)
+}
+
+// isPermissionError reports whether err is the API error for a restricted
+// user lacking a grant, error code 4 "Authentication failed"
+func isPermissionError(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "authentication failed") || strings.Contains(message, "not authorized")
+}
+
+// permissionError explains which grant a feature needs when err is a
+// permission error, and returns other errors unchanged
+func permissionError(feature, grant string, err error) error {
+	if !isPermissionError(err) {
+		return err
+	}
+	return fmt.Errorf("%s requires %s, which the user of the API key doesn't have: %s", feature, grant, err)
+}
+
+// isNotFound reports whether err is the API error for an unknown object,
+// error code 5 "Object not found"
+func isNotFound(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "object not found")
+}
+
+// envDefault returns the value of the fallback env var as the default of a
+// flag, so the flag and its primary env var still take precedence. Shared
+// shell profiles use these to set defaults once.
+func envDefault(fallbackEnv string, builtin int) int {
+	value := os.Getenv(fallbackEnv)
+	if value == "" {
+		return builtin
 	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warnf("Ignoring %s, %q is not a number", fallbackEnv, value)
+		return builtin
+	}
+	return v
+}
+
+// waitForPendingJobs checks with a growing interval until the linode has no
+// pending jobs left
+func (d *Driver) waitForPendingJobs(jobName string, timeOutSeconds int) error {
+	log.Debugf("Wait for job %s completion...", jobName)
+	return waitFor("Job "+jobName, timeOutSeconds, func() (bool, error) {
+		clientJobResponse, err := d.getClient().Job.List(d.LinodeId, -1, true)
+		if err != nil {
+			return false, err
+		}
+
+		if len(clientJobResponse.Jobs) == 0 {
+			log.Debugf("Linode job %s completed.", jobName)
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// waitForState checks the state of the linode with a growing interval until
+// it is in the desired state
+func (d *Driver) waitForState(desired state.State, timeOutSeconds int) error {
+	return waitFor("Waiting for machine "+desired.String(), timeOutSeconds, func() (bool, error) {
+		current, err := d.GetState()
+		if err != nil {
+			log.Debugf("Getting machine state failed: %s", err)
+		}
+		return current == desired, nil
+	})
+}
+
+// nonRoutableNetworks are the IPv4 ranges which can't be reached from the
+// internet: RFC1918 private, carrier-grade NAT, loopback and link-local
+var nonRoutableNetworks = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// privateIP reports whether ip is not a routable public address
+func privateIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return true
+	}
+
+	for _, network := range nonRoutableNetworks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// readAPIKeyFile sets APIKey to the contents of --linode-api-key-file
+func (d *Driver) readAPIKeyFile() error {
+	apiKey, err := ioutil.ReadFile(d.apiKeyFile)
+	if err != nil {
+		return fmt.Errorf("reading --linode-api-key-file: %s", err)
+	}
+
+	d.APIKey = strings.TrimSpace(string(apiKey))
+	if d.APIKey == "" {
+		return fmt.Errorf("--linode-api-key-file %s is empty", d.apiKeyFile)
+	}
+	return nil
 }
 
-// publicSSHKeyPath is always SSH Key Path appended with ".pub"
+// publicSSHKeyPath is always SSH Key Path appended with ".pub", the key path
+// is either --linode-ssh-key-path or the default from the machine store
 func (d *Driver) publicSSHKeyPath() string {
 	return d.GetSSHKeyPath() + ".pub"
 }
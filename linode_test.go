@@ -0,0 +1,1687 @@
+package linode
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/taoh/linodego"
+)
+
+// newTestFlags returns the create flags of the driver with their defaults,
+// an API key and the given values
+func newTestFlags(d *Driver, values map[string]interface{}) *drivers.CheckDriverOptions {
+	flagsValues := map[string]interface{}{
+		"linode-api-key": "KEY",
+	}
+	for key, value := range values {
+		flagsValues[key] = value
+	}
+	return &drivers.CheckDriverOptions{
+		FlagsValues: flagsValues,
+		CreateFlags: d.GetCreateFlags(),
+	}
+}
+
+func TestSetConfigFromFlags(t *testing.T) {
+	d := NewDriver("default", "path")
+	flags := newTestFlags(d, nil)
+
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(flags.InvalidFlags) > 0 {
+		t.Errorf("invalid flags: %v", flags.InvalidFlags)
+	}
+}
+
+func TestDockerPortValidation(t *testing.T) {
+	tests := []struct {
+		port int
+		err  string
+	}{
+		{port: defaultDockerPort},
+		{port: 1},
+		{port: 65535},
+		{port: 0, err: "--linode-docker-port must be between 1 and 65535, got 0"},
+		{port: -1, err: "--linode-docker-port must be between 1 and 65535, got -1"},
+		{port: 65536, err: "--linode-docker-port must be between 1 and 65535, got 65536"},
+	}
+
+	for _, test := range tests {
+		d := NewDriver("default", "path")
+		err := d.SetConfigFromFlags(newTestFlags(d, map[string]interface{}{
+			"linode-docker-port": test.port,
+		}))
+
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("port %d: unexpected error: %s", test.port, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("port %d: expected error %q, got %v", test.port, test.err, err)
+		}
+	}
+}
+
+func TestGetURL(t *testing.T) {
+	tests := []struct {
+		port int
+		url  string
+	}{
+		{port: 3376, url: "tcp://203.0.113.10:3376"},
+		// machines stored before the port was configurable
+		{port: 0, url: "tcp://203.0.113.10:2376"},
+	}
+
+	for _, test := range tests {
+		d := NewDriver("default", "path")
+		d.IPAddress = "203.0.113.10"
+		d.DockerPort = test.port
+
+		url, err := d.GetURL()
+		if err != nil {
+			t.Errorf("port %d: unexpected error: %s", test.port, err)
+			continue
+		}
+		if url != test.url {
+			t.Errorf("port %d: expected %s, got %s", test.port, test.url, url)
+		}
+	}
+}
+
+func TestGetPrivateURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		privateIP string
+		port      int
+		url       string
+		err       string
+	}{
+		{name: "private networking", privateIP: "192.168.130.10", port: 3376, url: "tcp://192.168.130.10:3376"},
+		{name: "default port", privateIP: "192.168.130.10", url: "tcp://192.168.130.10:2376"},
+		{
+			name: "no private networking",
+			port: 2376,
+			err:  "private IP address is not set, create the machine with --linode-private-networking",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			d.IPAddress = "203.0.113.10"
+			d.PrivateIPAddress = test.privateIP
+			d.DockerPort = test.port
+
+			url, err := d.GetPrivateURL()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if url != test.url {
+				t.Errorf("expected %s, got %s", test.url, url)
+			}
+		})
+	}
+}
+
+func TestGetIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		linodeId int
+		cached   string
+		ips      string
+		ip       string
+		err      string
+	}{
+		{name: "cached", linodeId: 42, cached: "203.0.113.20", ip: "203.0.113.20"},
+		{
+			name:     "recovered",
+			linodeId: 42,
+			ips:      `[{"IPADDRESSID":5,"IPADDRESS":"203.0.113.10","ISPUBLIC":1},{"IPADDRESSID":6,"IPADDRESS":"192.168.130.10","ISPUBLIC":0}]`,
+			ip:       "203.0.113.10",
+		},
+		{
+			name:     "no public IP",
+			linodeId: 42,
+			ips:      `[{"IPADDRESSID":6,"IPADDRESS":"192.168.130.10","ISPUBLIC":0}]`,
+			err:      "IP address is not set: Linode IP Address is not found.",
+		},
+		{name: "no linode", err: "IP address is not set"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &fakeAPI{handlers: map[string]func(url.Values) string{
+				"linode.ip.list": respond("linode.ip.list", test.ips),
+			}}
+			d := newTestDriver(api)
+			d.LinodeId = test.linodeId
+			d.IPAddress = test.cached
+
+			ip, err := d.GetIP()
+			if test.ips == "" && len(api.called()) > 0 {
+				t.Errorf("expected no API requests, got %v", api.called())
+			}
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ip != test.ip || d.IPAddress != test.ip {
+				t.Errorf("expected %s to be cached and returned, got %s cached and %s returned", test.ip, d.IPAddress, ip)
+			}
+		})
+	}
+}
+
+func TestGetSSHHostname(t *testing.T) {
+	tests := []struct {
+		name        string
+		overPrivate bool
+		privateIP   string
+		hostname    string
+	}{
+		{name: "over private", overPrivate: true, privateIP: "192.168.130.10", hostname: "192.168.130.10"},
+		{name: "over private without private IP", overPrivate: true, hostname: "203.0.113.10"},
+		{name: "public", privateIP: "192.168.130.10", hostname: "203.0.113.10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			d.IPAddress = "203.0.113.10"
+			d.PrivateIPAddress = test.privateIP
+			d.SSHOverPrivate = test.overPrivate
+
+			hostname, err := d.GetSSHHostname()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if hostname != test.hostname {
+				t.Errorf("expected %s, got %s", test.hostname, hostname)
+			}
+		})
+	}
+}
+
+func TestReadPublicSSHKey(t *testing.T) {
+	d := NewDriver("default", "path")
+	d.SSHKeyPath = filepath.Join(t.TempDir(), "id_rsa")
+	if err := ioutil.WriteFile(d.SSHKeyPath+".pub", []byte("ssh-rsa AAAA test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := d.readPublicSSHKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if publicKey != "ssh-rsa AAAA test" {
+		t.Errorf("expected the existing key, got %q", publicKey)
+	}
+}
+
+func TestCreateNoSSHKeyMissing(t *testing.T) {
+	d := NewDriver("default", "path")
+	d.SSHKeyPath = filepath.Join(t.TempDir(), "id_rsa")
+	d.NoSSHKey = true
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "id_rsa.pub does not exist") {
+		t.Errorf("expected a missing key error, got %v", err)
+	}
+	if _, err := os.Stat(d.SSHKeyPath); !os.IsNotExist(err) {
+		t.Errorf("expected no key to be generated, got %v", err)
+	}
+}
+
+func TestEnvDefaultPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		flag     interface{}
+		expected int
+	}{
+		{name: "built-in default", expected: defaultPlanId},
+		{name: "default env", env: "3", expected: 3},
+		{name: "invalid default env", env: "large", expected: defaultPlanId},
+		{name: "flag over default env", env: "3", flag: 4, expected: 4},
+		{name: "flag equal to built-in over default env", env: "3", flag: defaultPlanId, expected: defaultPlanId},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("LINODE_DEFAULT_PLAN_ID", test.env)
+
+			d := NewDriver("default", "path")
+			values := map[string]interface{}{}
+			if test.flag != nil {
+				values["linode-plan-id"] = test.flag
+			}
+			if err := d.SetConfigFromFlags(newTestFlags(d, values)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if d.PlanId != test.expected {
+				t.Errorf("expected plan %d, got %d", test.expected, d.PlanId)
+			}
+		})
+	}
+}
+
+// The primary env vars are applied by docker-machine over the flag default,
+// so they take precedence over the default env vars
+func TestEnvDefaultFlags(t *testing.T) {
+	t.Setenv("LINODE_DEFAULT_DATACENTER_ID", "3")
+	t.Setenv("LINODE_DEFAULT_PLAN_ID", "4")
+	t.Setenv("LINODE_DEFAULT_DISTRIBUTION_ID", "5")
+
+	expected := map[string]struct {
+		envVar string
+		value  int
+	}{
+		"linode-datacenter-id":   {"LINODE_DATACENTER_ID", 3},
+		"linode-plan-id":         {"LINODE_PLAN_ID", 4},
+		"linode-distribution-id": {"LINODE_DISTRIBUTION_ID", 5},
+	}
+
+	for _, flag := range NewDriver("default", "path").GetCreateFlags() {
+		intFlag, ok := flag.(mcnflag.IntFlag)
+		if !ok {
+			continue
+		}
+		if e, ok := expected[intFlag.Name]; ok {
+			if intFlag.EnvVar != e.envVar || intFlag.Value != e.value {
+				t.Errorf("%s: expected env var %s and default %d, got %s and %d",
+					intFlag.Name, e.envVar, e.value, intFlag.EnvVar, intFlag.Value)
+			}
+			delete(expected, intFlag.Name)
+		}
+	}
+	for name := range expected {
+		t.Errorf("flag %s is missing", name)
+	}
+}
+
+func TestPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"203.0.113.10", false},
+		{"45.33.10.20", false},
+		{"10.0.0.1", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"100.128.0.1", false},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"not an ip", true},
+	}
+
+	for _, test := range tests {
+		if private := privateIP(test.ip); private != test.private {
+			t.Errorf("privateIP(%q) = %v, expected %v", test.ip, private, test.private)
+		}
+	}
+}
+
+func TestGetClientConcurrent(t *testing.T) {
+	d := NewDriver("default", "path")
+	d.APIKey = "KEY"
+
+	clients := make([]*linodego.Client, 50)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i] = d.getClient()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, client := range clients {
+		if client == nil || client != clients[0] {
+			t.Fatalf("goroutine %d got client %p, expected %p", i, client, clients[0])
+		}
+	}
+}
+
+func TestSSHUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		expected string
+		err      string
+	}{
+		{name: "default", expected: "root"},
+		{name: "root", values: map[string]interface{}{"linode-ssh-user": "root"}, expected: "root"},
+		{
+			name:     "image",
+			values:   map[string]interface{}{"linode-ssh-user": "core", "linode-image-id": 1234},
+			expected: "core",
+		},
+		{
+			name:     "clone",
+			values:   map[string]interface{}{"linode-ssh-user": "core", "linode-clone-from": "base"},
+			expected: "core",
+		},
+		{
+			name:     "adopt",
+			values:   map[string]interface{}{"linode-ssh-user": "core", "linode-adopt": true, "linode-label": "node"},
+			expected: "core",
+		},
+		{
+			name:   "distribution",
+			values: map[string]interface{}{"linode-ssh-user": "core"},
+			err:    "--linode-ssh-user core requires --linode-image-id, --linode-clone-from or --linode-adopt",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			err := d.SetConfigFromFlags(newTestFlags(d, test.values))
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if user := d.GetSSHUsername(); user != test.expected {
+				t.Errorf("expected user %s, got %s", test.expected, user)
+			}
+		})
+	}
+}
+
+// fakeAPI answers Linode API requests with the responses of its handlers by
+// API action, and records the actions called
+type fakeAPI struct {
+	mu       sync.Mutex
+	actions  []string
+	params   []url.Values
+	handlers map[string]func(params url.Values) string
+}
+
+func (f *fakeAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	action := req.Form.Get("api_action")
+
+	f.mu.Lock()
+	f.actions = append(f.actions, action)
+	f.params = append(f.params, req.Form)
+	handler, ok := f.handlers[action]
+	f.mu.Unlock()
+
+	body := apiFailure(action, 3, "Action not implemented by the fake API")
+	if ok {
+		body = handler(req.Form)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// called returns the API actions requested so far
+func (f *fakeAPI) called() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.actions...)
+}
+
+// requests returns the parameters of the requests for the action
+func (f *fakeAPI) requests(action string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var requests []url.Values
+	for i, a := range f.actions {
+		if a == action {
+			requests = append(requests, f.params[i])
+		}
+	}
+	return requests
+}
+
+// respond returns a handler answering the action with the JSON data
+func respond(action, data string) func(url.Values) string {
+	return func(url.Values) string { return apiData(action, data) }
+}
+
+// failure returns a handler answering the action with an API error
+func failure(action, message string) func(url.Values) string {
+	return func(url.Values) string { return apiFailure(action, 0, message) }
+}
+
+// jobsFinished answers linode.job.list with the requested job finished, or
+// no pending jobs when all jobs are listed
+func jobsFinished(params url.Values) string {
+	if params.Get("JobID") == "" {
+		return apiData("linode.job.list", `[]`)
+	}
+	return apiData("linode.job.list", `[{"JOBID":`+params.Get("JobID")+`,"LABEL":"job","HOST_SUCCESS":"1"}]`)
+}
+
+// apiData returns an API response with the JSON data
+func apiData(action, data string) string {
+	return fmt.Sprintf(`{"ERRORARRAY":[],"ACTION":%q,"DATA":%s}`, action, data)
+}
+
+// apiFailure returns an API error response
+func apiFailure(action string, code int, message string) string {
+	return fmt.Sprintf(`{"ERRORARRAY":[{"ERRORCODE":%d,"ERRORMESSAGE":%q}],"ACTION":%q,"DATA":{}}`, code, message, action)
+}
+
+// newStoreDriver returns a driver with a machine directory in a temporary
+// store, where its SSH key is kept
+func newStoreDriver(t *testing.T) *Driver {
+	d := NewDriver("default", t.TempDir())
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0700); err != nil {
+		t.Fatal(err)
+	}
+	d.SSHKeyPath = d.ResolveStorePath("id_rsa")
+	return d
+}
+
+// logBuffer collects the driver log, it is written from other goroutines
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog collects the driver log including debug messages until the
+// test ends
+func captureLog(t *testing.T) *logBuffer {
+	buf := &logBuffer{}
+	log.SetDebug(true)
+	log.SetOutWriter(buf)
+	log.SetErrWriter(buf)
+	t.Cleanup(func() {
+		log.SetDebug(false)
+		log.SetOutWriter(os.Stdout)
+		log.SetErrWriter(os.Stderr)
+	})
+	return buf
+}
+
+// newTestDriver returns a driver whose API requests are answered by api
+func newTestDriver(api *fakeAPI) *Driver {
+	d := NewDriver("default", "path")
+	d.APIKey = "KEY"
+	useAPI(d, api)
+	return d
+}
+
+// useAPI makes api answer the API requests of the driver
+func useAPI(d *Driver, api *fakeAPI) {
+	d.clientOnce.Do(func() {
+		d.client = linodego.NewClient(d.APIKey, &http.Client{Transport: api})
+	})
+}
+
+// createAPI returns a fake API for creating linode 42 with the public IP
+// 203.0.113.10, from a distribution or as a clone of linode 7 "base"
+func createAPI() *fakeAPI {
+	return &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.create": respond("linode.create", `{"LinodeID":42}`),
+		"linode.clone":  respond("linode.clone", `{"LinodeID":42}`),
+		"linode.update": respond("linode.update", `{"LinodeID":42}`),
+		"linode.ip.list": respond("linode.ip.list", `[
+			{"IPADDRESSID":5,"LINODEID":42,"IPADDRESS":"203.0.113.10","ISPUBLIC":1}
+		]`),
+		"linode.disk.createfromdistribution": respond("linode.disk.createfromdistribution", `{"JobID":11,"DiskID":21}`),
+		"linode.disk.create":                 respond("linode.disk.create", `{"JobID":12,"DiskID":22}`),
+		"linode.disk.delete":                 respond("linode.disk.delete", `{"JobID":13,"DiskID":22}`),
+		"linode.job.list":                    jobsFinished,
+		"linode.config.create":               respond("linode.config.create", `{"ConfigID":31}`),
+		"linode.config.list": respond("linode.config.list", `[
+			{"ConfigID":31,"Label":"My Docker Machine Configuration","KernelID":210},
+			{"ConfigID":32,"Label":"rescue","KernelID":138}
+		]`),
+		"linode.boot": respond("linode.boot", `{"JobID":14}`),
+		"linode.list": respond("linode.list", `[
+			{"LINODEID":7,"STATUS":1,"LABEL":"base","PLANID":1,"DATACENTERID":2},
+			{"LINODEID":42,"STATUS":1,"LABEL":"node","PLANID":1,"DATACENTERID":2}
+		]`),
+		"linode.delete":          respond("linode.delete", `{"LinodeID":42}`),
+		"domain.list":            respond("domain.list", `[{"DOMAINID":5,"DOMAIN":"example.com"}]`),
+		"domain.resource.create": respond("domain.resource.create", `{"ResourceID":7}`),
+		"domain.resource.delete": respond("domain.resource.delete", `{"ResourceID":7}`),
+	}}
+}
+
+// newCreateDriver returns a driver configured with the create flags and
+// the given values, whose API requests are answered by api
+func newCreateDriver(t *testing.T, api *fakeAPI, values map[string]interface{}) *Driver {
+	d := newStoreDriver(t)
+	if err := d.SetConfigFromFlags(newTestFlags(d, values)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	useAPI(d, api)
+	return d
+}
+
+func TestValidateCombinedErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     map[string]interface{}
+		violations []string
+	}{
+		{
+			name: "conflicting sources",
+			values: map[string]interface{}{
+				"linode-image-id":       1234,
+				"linode-clone-from":     "base",
+				"linode-dns-domain":     "example.com",
+				"linode-data-disk-size": -1,
+				"linode-max-parallel":   0,
+			},
+			violations: []string{
+				"--linode-image-id, --linode-clone-from and --linode-adopt are mutually exclusive",
+				"--linode-dns-domain and --linode-dns-record must be set together",
+				"--linode-data-disk-size must not be negative, got -1",
+				"--linode-max-parallel must be at least 1, got 0",
+			},
+		},
+		{
+			name: "missing dependencies",
+			values: map[string]interface{}{
+				"linode-api-key":           "",
+				"linode-adopt":             true,
+				"linode-label-auto-suffix": true,
+				"linode-min-memory":        4096,
+			},
+			violations: []string{
+				"linode driver requires the --linode-api-key or --linode-api-key-file option",
+				"--linode-adopt requires the --linode-label of the linode to adopt",
+				"--linode-label-auto-suffix requires --linode-label",
+				"--linode-label-auto-suffix can't be used with --linode-adopt",
+				"--linode-min-memory and --linode-min-cores require --linode-plan-class",
+			},
+		},
+		{
+			name: "invalid values",
+			values: map[string]interface{}{
+				"linode-api-rate":       0,
+				"linode-swap-size":      "auto:3",
+				"linode-data-disk-fs":   "xfs",
+				"linode-deploy-timeout": 0,
+				"linode-plan-class":     "gpu",
+			},
+			violations: []string{
+				"--linode-api-rate must be at least 1 request per second, or -1 to disable the limit, got 0",
+				"--linode-swap-size ratio must be above 0 and at most 2, got \"auto:3\"",
+				"--linode-data-disk-fs must be ext4, ext3 or raw, got \"xfs\"",
+				"--linode-deploy-timeout must be at least 1 second, got 0",
+				"--linode-plan-class must be standard, highmem or dedicated, got \"gpu\"",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			err := d.SetConfigFromFlags(newTestFlags(d, test.values))
+
+			expected := "invalid linode driver options:\n  " + strings.Join(test.violations, "\n  ")
+			if err == nil || err.Error() != expected {
+				t.Errorf("expected error:\n%s\ngot:\n%v", expected, err)
+			}
+		})
+	}
+}
+
+func TestAPIRateValidation(t *testing.T) {
+	tests := []struct {
+		rate int
+		err  bool
+	}{
+		{rate: defaultAPIRate},
+		{rate: 1},
+		{rate: -1},
+		{rate: 0, err: true},
+		{rate: -2, err: true},
+	}
+
+	for _, test := range tests {
+		d := NewDriver("default", "path")
+		err := d.SetConfigFromFlags(newTestFlags(d, map[string]interface{}{"linode-api-rate": test.rate}))
+		if test.err != (err != nil) {
+			t.Errorf("rate %d: expected error %v, got %v", test.rate, test.err, err)
+		}
+	}
+}
+
+// pendingJobAPI returns a fake API whose jobs never finish
+func pendingJobAPI() *fakeAPI {
+	return &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.disk.createfromdistribution": func(url.Values) string {
+			return apiData("linode.disk.createfromdistribution", `{"JobID":11,"DiskID":21}`)
+		},
+		"linode.reboot": func(url.Values) string {
+			return apiData("linode.reboot", `{"JobID":12}`)
+		},
+		"linode.job.list": func(params url.Values) string {
+			return apiData("linode.job.list", `[{"JOBID":`+params.Get("JobID")+`,"LABEL":"job","HOST_SUCCESS":""}]`)
+		},
+	}}
+}
+
+func TestDeployAndBootTimeouts(t *testing.T) {
+	tests := []struct {
+		name          string
+		deployTimeout int
+		bootTimeout   int
+		run           func(d *Driver) error
+		err           string
+	}{
+		{
+			name:          "deploy",
+			deployTimeout: 1,
+			bootTimeout:   30,
+			run: func(d *Driver) error {
+				return d.createDisk(diskTask{
+					name: "Primary Disk",
+					create: func() (*linodego.LinodeDiskJobResponse, error) {
+						return d.getClient().Disk.CreateFromDistribution(d.DistributionId, d.LinodeId, "Primary Disk", d.primaryDiskSize(), nil)
+					},
+					diskId: &d.DiskId,
+				})
+			},
+			err: "Job Create Primary Disk timed out after 1 seconds.",
+		},
+		{
+			name:          "boot",
+			deployTimeout: 30,
+			bootTimeout:   1,
+			run:           (*Driver).Restart,
+			err:           "Job Rebooting linode timed out after 1 seconds.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDriver(pendingJobAPI())
+			d.LinodeId = 42
+			d.DistributionId = defaultDistributionId
+			d.DeployTimeout = test.deployTimeout
+			d.BootTimeout = test.bootTimeout
+
+			if err := test.run(d); err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte("  FILEKEY\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		key    string
+		err    string
+	}{
+		{
+			name:   "flag",
+			values: map[string]interface{}{"linode-api-key": "FLAGKEY"},
+			key:    "FLAGKEY",
+		},
+		{
+			name:   "file trimmed",
+			values: map[string]interface{}{"linode-api-key": "", "linode-api-key-file": keyFile},
+			key:    "FILEKEY",
+		},
+		{
+			name:   "both",
+			values: map[string]interface{}{"linode-api-key": "FLAGKEY", "linode-api-key-file": keyFile},
+			err:    "--linode-api-key (or LINODE_API_KEY) and --linode-api-key-file are ambiguous, set only one of them",
+		},
+		{
+			name:   "missing file",
+			values: map[string]interface{}{"linode-api-key": "", "linode-api-key-file": filepath.Join(dir, "missing")},
+			err:    "reading --linode-api-key-file: open " + filepath.Join(dir, "missing"),
+		},
+		{
+			name:   "empty file",
+			values: map[string]interface{}{"linode-api-key": "", "linode-api-key-file": emptyFile},
+			err:    "--linode-api-key-file " + emptyFile + " is empty",
+		},
+		{
+			name:   "neither",
+			values: map[string]interface{}{"linode-api-key": ""},
+			err:    "linode driver requires the --linode-api-key or --linode-api-key-file option",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			// the other problems are reported together with the key
+			values := map[string]interface{}{"linode-max-parallel": 0}
+			for key, value := range test.values {
+				values[key] = value
+			}
+			err := d.SetConfigFromFlags(newTestFlags(d, values))
+
+			if err == nil || !strings.Contains(err.Error(), "--linode-max-parallel must be at least 1") {
+				t.Errorf("expected the other problems to be reported, got %v", err)
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if d.APIKey != test.key {
+				t.Errorf("expected API key %q, got %q", test.key, d.APIKey)
+			}
+		})
+	}
+}
+
+func TestRootPasswordValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		err    string
+	}{
+		{name: "generated"},
+		{name: "compliant", values: map[string]interface{}{"linode-root-pass": "Sup3rSecretPass"}},
+		{
+			name:   "weak",
+			values: map[string]interface{}{"linode-root-pass": "secret"},
+			err:    "--linode-root-pass must be at least 11 characters long and at least 3 of lower case, upper case, digit and punctuation characters",
+		},
+		{
+			name:   "adopted linode keeps its password",
+			values: map[string]interface{}{"linode-root-pass": "secret", "linode-adopt": true, "linode-label": "node"},
+		},
+		{name: "clone keeps its password", values: map[string]interface{}{"linode-clone-from": "base"}},
+		{
+			name:   "clone with password",
+			values: map[string]interface{}{"linode-root-pass": "Sup3rSecretPass", "linode-clone-from": "base"},
+			err:    "--linode-root-pass can't be used with --linode-clone-from, the clone keeps the root password of the source",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			err := d.SetConfigFromFlags(newTestFlags(d, test.values))
+
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestKernel(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		kernelId int
+		err      string
+	}{
+		{name: "default", kernelId: kernels["grub2"]},
+		{name: "kernel id", values: map[string]interface{}{"linode-kernel-id": 138}, kernelId: 138},
+		{name: "grub2", values: map[string]interface{}{"linode-kernel": "grub2"}, kernelId: 210},
+		{name: "latest", values: map[string]interface{}{"linode-kernel": "latest"}, kernelId: 138},
+		{
+			name:     "direct-disk",
+			values:   map[string]interface{}{"linode-kernel": "direct-disk", "linode-image-id": 1234},
+			kernelId: 213,
+		},
+		{
+			name:     "same kernel by name and id",
+			values:   map[string]interface{}{"linode-kernel": "latest", "linode-kernel-id": 138},
+			kernelId: 138,
+		},
+		{
+			name:   "unknown",
+			values: map[string]interface{}{"linode-kernel": "mainline"},
+			err:    "--linode-kernel must be grub2, direct-disk or latest, got \"mainline\"",
+		},
+		{
+			name:   "kernel by name and other id",
+			values: map[string]interface{}{"linode-kernel": "latest", "linode-kernel-id": 215},
+			err:    "--linode-kernel latest and --linode-kernel-id 215 are ambiguous, set only one of them",
+		},
+		{
+			name:   "direct-disk distribution",
+			values: map[string]interface{}{"linode-kernel": "direct-disk"},
+			err:    "the direct-disk kernel needs a disk with a boot loader",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			err := d.SetConfigFromFlags(newTestFlags(d, test.values))
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if d.KernelId != test.kernelId {
+				t.Errorf("expected kernel %d, got %d", test.kernelId, d.KernelId)
+			}
+		})
+	}
+}
+
+func TestDeployDisksKernel(t *testing.T) {
+	var config url.Values
+	api := &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.disk.createfromdistribution": func(url.Values) string {
+			return apiData("linode.disk.createfromdistribution", `{"JobID":11,"DiskID":21}`)
+		},
+		"linode.disk.create": func(url.Values) string {
+			return apiData("linode.disk.create", `{"JobID":12,"DiskID":22}`)
+		},
+		"linode.job.list": func(params url.Values) string {
+			return apiData("linode.job.list", `[{"JOBID":`+params.Get("JobID")+`,"LABEL":"job","HOST_SUCCESS":"1"}]`)
+		},
+		"linode.config.create": func(params url.Values) string {
+			config = params
+			return apiData("linode.config.create", `{"ConfigID":31}`)
+		},
+	}}
+	d := newTestDriver(api)
+	d.LinodeId = 42
+	d.KernelId = kernels["latest"]
+	d.SwapSize = defaultSwapSize
+	d.ConfigDevices = "root,swap"
+	d.DeployTimeout = 5
+	d.MaxParallel = 1
+
+	if err := d.deployDisks("ssh-rsa AAAA test"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if kernelId := config.Get("KernelID"); kernelId != "138" {
+		t.Errorf("expected the configuration profile to boot kernel 138, got %s", kernelId)
+	}
+	if d.ConfigId != 31 {
+		t.Errorf("expected configuration profile 31, got %d", d.ConfigId)
+	}
+}
+
+func TestStop(t *testing.T) {
+	polls := 0
+	api := &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.shutdown": respond("linode.shutdown", `{"JobID":12}`),
+		"linode.job.list": func(params url.Values) string {
+			polls++
+			if polls < 3 {
+				return apiData("linode.job.list", `[{"JOBID":12,"LABEL":"job","HOST_SUCCESS":""}]`)
+			}
+			return jobsFinished(params)
+		},
+	}}
+	d := newTestDriver(api)
+	d.LinodeId = 42
+	d.ShutdownWait = 30
+
+	if err := d.Stop(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if polls != 3 {
+		t.Errorf("expected the job to be polled until it finished, got %d polls", polls)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	d := newTestDriver(&fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.shutdown": respond("linode.shutdown", `{"JobID":12}`),
+		"linode.job.list": respond("linode.job.list", `[{"JOBID":12,"LABEL":"job","HOST_SUCCESS":""}]`),
+	}})
+	d.LinodeId = 42
+	d.ShutdownWait = 1
+
+	if err := d.Stop(); err == nil || err.Error() != "Job Shutting down linode timed out after 1 seconds." {
+		t.Errorf("expected a timeout after the shutdown wait, got %v", err)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		warning  bool
+	}{
+		{name: "same IP", previous: "203.0.113.10"},
+		{name: "changed IP", previous: "203.0.113.99", warning: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLog(t)
+			api := createAPI()
+			api.handlers["linode.reboot"] = respond("linode.reboot", `{"JobID":15}`)
+			// rebooting until the second poll
+			api.handlers["linode.list"] = func(url.Values) string {
+				status := 2
+				if len(api.requests("linode.list")) > 1 {
+					status = 1
+				}
+				return apiData("linode.list", fmt.Sprintf(`[{"LINODEID":42,"STATUS":%d,"LABEL":"node"}]`, status))
+			}
+			d := newTestDriver(api)
+			d.LinodeId = 42
+			d.ConfigId = 31
+			d.IPAddress = test.previous
+
+			if err := d.Restart(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			reboots := api.requests("linode.reboot")
+			if len(reboots) != 1 || reboots[0].Get("LinodeID") != "42" || reboots[0].Get("ConfigID") != "31" {
+				t.Errorf("expected linode 42 to be rebooted into configuration profile 31, got %v", reboots)
+			}
+			if polls := len(api.requests("linode.list")); polls != 2 {
+				t.Errorf("expected the state to be polled until running, got %d polls", polls)
+			}
+			if d.IPAddress != "203.0.113.10" {
+				t.Errorf("expected the IP to be looked up again, got %s", d.IPAddress)
+			}
+			warned := strings.Contains(logs.String(), "IP address of linode 42 changed from "+test.previous)
+			if warned != test.warning {
+				t.Errorf("expected warning %v, got logs %q", test.warning, logs.String())
+			}
+		})
+	}
+}
+
+func TestKill(t *testing.T) {
+	api := &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.shutdown": respond("linode.shutdown", `{"JobID":12}`),
+	}}
+	d := newTestDriver(api)
+	d.LinodeId = 42
+
+	if err := d.Kill(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if actions := api.called(); !reflect.DeepEqual(actions, []string{"linode.shutdown"}) {
+		t.Errorf("expected only a shutdown without waiting, got %v", actions)
+	}
+}
+
+// resizeAPI returns a fake API for resizing linode 42 to a plan with 48GB
+// of disk space, on which the primary disk 21 has the given size
+func resizeAPI(primarySize int) *fakeAPI {
+	return &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.resize":      respond("linode.resize", `{}`),
+		"linode.job.list":    jobsFinished,
+		"linode.list":        respond("linode.list", `[{"LINODEID":42,"STATUS":1,"PLANID":2,"TOTALHD":49152}]`),
+		"linode.disk.list":   respond("linode.disk.list", fmt.Sprintf(`[{"DISKID":21,"LABEL":"Primary Disk","TYPE":"ext4","SIZE":%d},{"DISKID":22,"LABEL":"Swap Disk","TYPE":"swap","SIZE":256}]`, primarySize)),
+		"linode.disk.resize": respond("linode.disk.resize", `{"JobID":13,"DiskID":21}`),
+	}}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name        string
+		resizeDisk  bool
+		primarySize int
+		actions     []string
+		size        string
+	}{
+		{
+			name:        "plan only",
+			primarySize: 20224,
+			actions:     []string{"linode.resize", "linode.job.list"},
+		},
+		{
+			name:        "grow primary disk",
+			resizeDisk:  true,
+			primarySize: 20224,
+			actions:     []string{"linode.resize", "linode.job.list", "linode.list", "linode.disk.list", "linode.disk.resize", "linode.job.list"},
+			size:        "48896",
+		},
+		{
+			name:        "primary disk already fills the plan",
+			resizeDisk:  true,
+			primarySize: 48896,
+			actions:     []string{"linode.resize", "linode.job.list", "linode.list", "linode.disk.list"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := resizeAPI(test.primarySize)
+			d := newTestDriver(api)
+			d.LinodeId = 42
+			d.DiskId = 21
+			d.PlanId = 1
+			d.ResizeDisk = test.resizeDisk
+
+			if err := d.Resize(2); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if d.PlanId != 2 {
+				t.Errorf("expected plan 2, got %d", d.PlanId)
+			}
+			if actions := api.called(); !reflect.DeepEqual(actions, test.actions) {
+				t.Errorf("expected actions %v, got %v", test.actions, actions)
+			}
+			if test.size != "" {
+				if size := api.requests("linode.disk.resize")[0].Get("size"); size != test.size {
+					t.Errorf("expected the primary disk to grow to %sMB, got %sMB", test.size, size)
+				}
+			}
+		})
+	}
+}
+
+func TestSSHKeyPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		expected string
+	}{
+		{name: "default", expected: filepath.Join("path", "machines", "default", "id_rsa")},
+		{
+			name:     "flag",
+			values:   map[string]interface{}{"linode-ssh-key-path": "/keys/deploy"},
+			expected: "/keys/deploy",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDriver("default", "path")
+			if err := d.SetConfigFromFlags(newTestFlags(d, test.values)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if path := d.GetSSHKeyPath(); path != test.expected {
+				t.Errorf("expected key %s, got %s", test.expected, path)
+			}
+			if path := d.publicSSHKeyPath(); path != test.expected+".pub" {
+				t.Errorf("expected public key %s.pub, got %s", test.expected, path)
+			}
+		})
+	}
+}
+
+func TestCreateSSHKey(t *testing.T) {
+	writeKey := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		existing bool
+		generate func(path string) error
+		key      string
+		err      string
+	}{
+		{
+			name: "generated",
+			generate: func(path string) error {
+				writeKey(path, "PRIVATE")
+				writeKey(path+".pub", "ssh-rsa GENERATED")
+				return nil
+			},
+			key: "ssh-rsa GENERATED",
+		},
+		{
+			name:     "existing",
+			existing: true,
+			generate: func(path string) error {
+				return fmt.Errorf("must not be called")
+			},
+			key: "ssh-rsa EXISTING",
+		},
+		{
+			name: "generation fails",
+			generate: func(path string) error {
+				writeKey(path, "PARTIAL")
+				return fmt.Errorf("disk full")
+			},
+			err: "generating SSH key %s: disk full",
+		},
+		{
+			name: "reading fails",
+			generate: func(path string) error {
+				writeKey(path, "PRIVATE")
+				return nil
+			},
+			err: "reading generated SSH key: SSH public key %s.pub does not exist",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(generate func(string) error) { generateSSHKey = generate }(generateSSHKey)
+			generateSSHKey = test.generate
+
+			d := newStoreDriver(t)
+			if test.existing {
+				writeKey(d.GetSSHKeyPath(), "PRIVATE")
+				writeKey(d.publicSSHKeyPath(), "ssh-rsa EXISTING")
+			}
+
+			key, err := d.createSSHKey()
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if key != test.key {
+					t.Errorf("expected key %q, got %q", test.key, key)
+				}
+				return
+			}
+
+			if expected := fmt.Sprintf(test.err, d.GetSSHKeyPath()); err == nil || err.Error() != expected {
+				t.Errorf("expected error %q, got %v", expected, err)
+			}
+			for _, path := range []string{d.GetSSHKeyPath(), d.publicSSHKeyPath()} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("expected %s to be removed, got %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckImage(t *testing.T) {
+	images := respond("image.list", `[
+		{"IMAGEID":301,"LABEL":"docker-base","STATUS":"available","MINSIZE":4096},
+		{"IMAGEID":302,"LABEL":"docker-next","STATUS":"pending_upload","MINSIZE":4096},
+		{"IMAGEID":303,"LABEL":"docker-full","STATUS":"available","MINSIZE":30000}
+	]`)
+
+	tests := []struct {
+		imageId int
+		err     string
+	}{
+		{imageId: 301},
+		{imageId: 302, err: "image 302 (docker-next) is pending_upload, not available"},
+		{imageId: 303, err: "image 303 (docker-full) needs 30000MB, more than the 20224MB primary disk"},
+		{imageId: 304, err: "image 304 is not found or not accessible with this API key"},
+	}
+
+	for _, test := range tests {
+		d := newTestDriver(&fakeAPI{handlers: map[string]func(url.Values) string{"image.list": images}})
+		d.ImageId = test.imageId
+		d.SwapSize = defaultSwapSize
+
+		err := d.checkImage()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("image %d: unexpected error: %s", test.imageId, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("image %d: expected error %q, got %v", test.imageId, test.err, err)
+		}
+	}
+}
+
+func TestCreateBootConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		bootConfig string
+		configId   string
+		err        string
+	}{
+		{name: "created profile", configId: "31"},
+		{name: "named profile", bootConfig: "rescue", configId: "32"},
+		{
+			name:       "unknown profile",
+			bootConfig: "missing",
+			err:        `configuration profile "missing" is not found on linode 42, available: My Docker Machine Configuration, rescue`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := createAPI()
+			d := newCreateDriver(t, api, map[string]interface{}{
+				"linode-boot-config": test.bootConfig,
+			})
+
+			err := d.Create()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				if boots := api.requests("linode.boot"); len(boots) > 0 {
+					t.Errorf("expected no boot, got %v", boots)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			boots := api.requests("linode.boot")
+			if len(boots) != 1 || boots[0].Get("ConfigID") != test.configId {
+				t.Errorf("expected configuration profile %s to be booted, got %v", test.configId, boots)
+			}
+		})
+	}
+}
+
+func TestCreateClone(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "by label", source: "base"},
+		{name: "by id", source: "7"},
+		{name: "missing source", source: "gone", err: "Linode gone is not found."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := createAPI()
+			d := newCreateDriver(t, api, map[string]interface{}{
+				"linode-clone-from": test.source,
+			})
+			if d.RootPassword != "" || d.RootPasswordGenerated {
+				t.Errorf("expected no root password for a clone, got %q", d.RootPassword)
+			}
+
+			err := d.Create()
+			if _, statErr := os.Stat(d.ResolveStorePath(rootPasswordFile)); !os.IsNotExist(statErr) {
+				t.Errorf("expected no root password file for a clone, got %v", statErr)
+			}
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				if clones := api.requests("linode.clone"); len(clones) > 0 {
+					t.Errorf("expected no clone, got %v", clones)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			clones := api.requests("linode.clone")
+			if len(clones) != 1 || clones[0].Get("LinodeID") != "7" {
+				t.Errorf("expected linode 7 to be cloned, got %v", clones)
+			}
+			for _, action := range []string{"linode.create", "linode.disk.createfromdistribution", "linode.config.create"} {
+				if requests := api.requests(action); len(requests) > 0 {
+					t.Errorf("expected no %s for a clone, got %v", action, requests)
+				}
+			}
+			boots := api.requests("linode.boot")
+			if len(boots) != 1 || boots[0].Get("ConfigID") != "31" {
+				t.Errorf("expected the first configuration profile to be booted, got %v", boots)
+			}
+			if d.LinodeId != 42 || d.IPAddress != "203.0.113.10" {
+				t.Errorf("expected linode 42 at 203.0.113.10, got %d at %s", d.LinodeId, d.IPAddress)
+			}
+		})
+	}
+}
+
+func TestCheckLabel(t *testing.T) {
+	tests := []struct {
+		name       string
+		label      string
+		autoSuffix bool
+		expected   string
+		err        string
+	}{
+		{name: "free", label: "fresh", expected: "fresh"},
+		{
+			name:  "in use",
+			label: "node",
+			err:   "Linode label node is already in use, choose another --linode-label or set --linode-label-auto-suffix",
+		},
+		{name: "auto suffix", label: "node", autoSuffix: true, expected: "node-2"},
+		{name: "auto suffix unused", label: "fresh", autoSuffix: true, expected: "fresh"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDriver(&fakeAPI{handlers: map[string]func(url.Values) string{
+				"linode.list": respond("linode.list", `[
+					{"LINODEID":7,"LABEL":"node"},
+					{"LINODEID":8,"LABEL":"node-1"}
+				]`),
+			}})
+			d.LinodeLabel = test.label
+			d.LabelAutoSuffix = test.autoSuffix
+
+			err := d.checkLabel()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if d.LinodeLabel != test.expected {
+				t.Errorf("expected label %q, got %q", test.expected, d.LinodeLabel)
+			}
+		})
+	}
+}
+
+func TestCreateAdopt(t *testing.T) {
+	tests := []struct {
+		name   string
+		label  string
+		status int
+		booted bool
+		err    string
+	}{
+		{name: "running", label: "node", status: 1},
+		{name: "powered off", label: "node", status: 2, booted: true},
+		{name: "missing", label: "gone", err: "Linode gone is not found."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := createAPI()
+			api.handlers["linode.list"] = func(params url.Values) string {
+				status := test.status
+				if len(api.requests("linode.boot")) > 0 {
+					status = 1
+				}
+				return apiData("linode.list", fmt.Sprintf(`[{"LINODEID":42,"STATUS":%d,"LABEL":"node"}]`, status))
+			}
+			d := newCreateDriver(t, api, map[string]interface{}{
+				"linode-adopt": true,
+				"linode-label": test.label,
+			})
+
+			err := d.Create()
+			if _, statErr := os.Stat(d.ResolveStorePath(rootPasswordFile)); !os.IsNotExist(statErr) {
+				t.Errorf("expected no root password file for an adopted linode, got %v", statErr)
+			}
+			for _, action := range []string{"linode.create", "linode.clone", "linode.update", "linode.delete"} {
+				if requests := api.requests(action); len(requests) > 0 {
+					t.Errorf("expected no %s when adopting, got %v", action, requests)
+				}
+			}
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if d.LinodeId != 42 || d.IPAddress != "203.0.113.10" {
+				t.Errorf("expected linode 42 at 203.0.113.10, got %d at %s", d.LinodeId, d.IPAddress)
+			}
+			if booted := len(api.requests("linode.boot")) > 0; booted != test.booted {
+				t.Errorf("expected booted %v, got %v", test.booted, booted)
+			}
+		})
+	}
+}
+
+func TestCreateWatchdog(t *testing.T) {
+	tests := []struct {
+		noWatchdog bool
+		watchdog   string
+	}{
+		{noWatchdog: false, watchdog: "true"},
+		{noWatchdog: true, watchdog: "false"},
+	}
+
+	for _, test := range tests {
+		api := createAPI()
+		d := newCreateDriver(t, api, map[string]interface{}{
+			"linode-no-watchdog": test.noWatchdog,
+		})
+
+		if err := d.Create(); err != nil {
+			t.Errorf("no watchdog %v: unexpected error: %s", test.noWatchdog, err)
+			continue
+		}
+		updates := api.requests("linode.update")
+		if len(updates) != 1 || updates[0].Get("watchdog") != test.watchdog {
+			t.Errorf("no watchdog %v: expected watchdog %s, got %v", test.noWatchdog, test.watchdog, updates)
+		}
+	}
+}
+
+func TestPreCreateCheckPermissions(t *testing.T) {
+	denied := func(action string) func(url.Values) string {
+		return func(url.Values) string {
+			return apiFailure(action, 4, "Authentication failed")
+		}
+	}
+
+	tests := []struct {
+		name     string
+		feature  func(d *Driver)
+		handlers map[string]func(url.Values) string
+		err      string
+	}{
+		{
+			name:     "clone from",
+			feature:  func(d *Driver) { d.CloneFrom = "base" },
+			handlers: map[string]func(url.Values) string{"linode.list": denied("linode.list")},
+			err:      "--linode-clone-from requires read/write access to the source linode, which the user of the API key doesn't have: Authentication failed",
+		},
+		{
+			name:     "dns domain",
+			feature:  func(d *Driver) { d.DNSDomain = "example.com" },
+			handlers: map[string]func(url.Values) string{"domain.list": denied("domain.list")},
+			err:      "--linode-dns-domain requires the DNS manager grant, which the user of the API key doesn't have: Authentication failed",
+		},
+		{
+			name:     "image id",
+			feature:  func(d *Driver) { d.ImageId = 1234 },
+			handlers: map[string]func(url.Values) string{"image.list": denied("image.list")},
+			err:      "--linode-image-id requires access to the image, which the user of the API key doesn't have: looking up image 1234: Authentication failed",
+		},
+		{
+			name:    "other errors unchanged",
+			feature: func(d *Driver) { d.DNSDomain = "example.org" },
+			handlers: map[string]func(url.Values) string{
+				"domain.list": respond("domain.list", `[{"DOMAINID":5,"DOMAIN":"example.com"}]`),
+			},
+			err: "DNS domain example.org is not found on the account",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDriver(&fakeAPI{handlers: test.handlers})
+			test.feature(d)
+
+			if err := d.PreCreateCheck(); err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestGetStateLog(t *testing.T) {
+	logs := captureLog(t)
+	api := &fakeAPI{handlers: map[string]func(url.Values) string{
+		"linode.list": respond("linode.list", `[
+			{"LINODEID":42,"STATUS":2,"LABEL":"node","PLANID":3,"DATACENTERID":7,"CREATE_DT":"2016-11-08 18:04:11.0"}
+		]`),
+	}}
+	d := newTestDriver(api)
+	d.LinodeId = 42
+	d.IPAddress = "203.0.113.10"
+	// stored values which are out of date, the log shows the linode's own
+	d.PlanId = 1
+	d.DataCenterId = 2
+
+	current, err := d.GetState()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if current != state.Stopped {
+		t.Errorf("expected %s, got %s", state.Stopped, current)
+	}
+	for _, expected := range []string{"Linode 42 node", "status 2", "plan 3", "data center 7", "created 2016-11-08", "IP address 203.0.113.10"} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("expected the log to contain %q, got %q", expected, logs.String())
+		}
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	tests := []struct {
+		name          string
+		values        map[string]interface{}
+		handlers      map[string]func(url.Values) string
+		recordRemoved bool
+		noRollback    bool
+	}{
+		{name: "update", handlers: map[string]func(url.Values) string{"linode.update": failure("linode.update", "Validation error")}},
+		{name: "IP lookup", handlers: map[string]func(url.Values) string{"linode.ip.list": failure("linode.ip.list", "Object not found")}},
+		{name: "disk", handlers: map[string]func(url.Values) string{"linode.disk.create": failure("linode.disk.create", "Not enough space")}},
+		{name: "config", handlers: map[string]func(url.Values) string{"linode.config.create": failure("linode.config.create", "Validation error")}},
+		{name: "boot", handlers: map[string]func(url.Values) string{"linode.boot": failure("linode.boot", "Linode busy")}},
+		{
+			name:   "never running",
+			values: map[string]interface{}{"linode-boot-timeout": 1},
+			handlers: map[string]func(url.Values) string{
+				"linode.list": respond("linode.list", `[{"LINODEID":42,"STATUS":-2,"LABEL":"node"}]`),
+			},
+		},
+		{
+			name:     "DNS record",
+			values:   map[string]interface{}{"linode-dns-domain": "example.com", "linode-dns-record": "node1"},
+			handlers: map[string]func(url.Values) string{"domain.resource.create": failure("domain.resource.create", "Validation error")},
+		},
+		{
+			name:          "after the DNS record",
+			values:        map[string]interface{}{"linode-dns-domain": "example.com", "linode-dns-record": "node1", "linode-rdns": "node1.example.com"},
+			handlers:      map[string]func(url.Values) string{"linode.ip.setrdns": failure("linode.ip.setrdns", "Validation error")},
+			recordRemoved: true,
+		},
+		{
+			name:       "no rollback",
+			values:     map[string]interface{}{"linode-no-rollback": true},
+			handlers:   map[string]func(url.Values) string{"linode.boot": failure("linode.boot", "Linode busy")},
+			noRollback: true,
+		},
+	}
+
+	defer func(lookup func(string) ([]string, error)) { lookupHost = lookup }(lookupHost)
+	lookupHost = func(host string) ([]string, error) {
+		return []string{"203.0.113.10"}, nil
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := createAPI()
+			for action, handler := range test.handlers {
+				api.handlers[action] = handler
+			}
+			d := newCreateDriver(t, api, test.values)
+
+			if err := d.Create(); err == nil {
+				t.Fatal("expected create to fail")
+			}
+
+			deletes := api.requests("linode.delete")
+			if test.noRollback {
+				if len(deletes) > 0 {
+					t.Errorf("expected no rollback, got %v", deletes)
+				}
+				if d.LinodeId != 42 {
+					t.Errorf("expected linode 42 to be kept, got %d", d.LinodeId)
+				}
+				return
+			}
+			if len(deletes) != 1 || deletes[0].Get("LinodeID") != "42" {
+				t.Errorf("expected linode 42 to be deleted, got %v", deletes)
+			}
+			if removed := len(api.requests("domain.resource.delete")) == 1; removed != test.recordRemoved {
+				t.Errorf("expected DNS record removed %v, got %v", test.recordRemoved, removed)
+			}
+			if d.LinodeId != 0 || d.IPAddress != "" {
+				t.Errorf("expected the linode to be forgotten, got %d at %s", d.LinodeId, d.IPAddress)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package linode
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+const (
+	rootPasswordFile   = "root_password"
+	rootPasswordLength = 24
+	rootPasswordChars  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#%^*-_=+"
+)
+
+// generateRootPassword returns a random password containing lower case,
+// upper case and digit characters
+func generateRootPassword() (string, error) {
+	max := big.NewInt(int64(len(rootPasswordChars)))
+	for {
+		password := make([]byte, rootPasswordLength)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			password[i] = rootPasswordChars[n.Int64()]
+		}
+
+		if strings.IndexFunc(string(password), unicode.IsLower) >= 0 &&
+			strings.IndexFunc(string(password), unicode.IsUpper) >= 0 &&
+			strings.IndexFunc(string(password), unicode.IsDigit) >= 0 {
+			return string(password), nil
+		}
+	}
+}
+
+// saveRootPassword writes a driver generated root password to the machine
+// store, readable by the owner only
+func (d *Driver) saveRootPassword() error {
+	return ioutil.WriteFile(d.ResolveStorePath(rootPasswordFile), []byte(d.RootPassword), 0600)
+}
+
+// GetRootPassword returns the root password generated by the driver
+func (d *Driver) GetRootPassword() (string, error) {
+	password, err := ioutil.ReadFile(d.ResolveStorePath(rootPasswordFile))
+	if err != nil {
+		return "", fmt.Errorf("reading generated root password: %s", err)
+	}
+	return string(password), nil
+}
+
+const (
+	rootPasswordMinLength  = 11
+	rootPasswordMinClasses = 3
+)
+
+// rootPasswordProblems returns the complexity rules the root password
+// doesn't meet, Linode rejects weak root passwords
+func rootPasswordProblems(password string) []string {
+	var problems []string
+	if len(password) < rootPasswordMinLength {
+		problems = append(problems, fmt.Sprintf("at least %d characters long", rootPasswordMinLength))
+	}
+
+	classes := 0
+	for _, class := range []func(rune) bool{unicode.IsLower, unicode.IsUpper, unicode.IsDigit, isPunct} {
+		if strings.IndexFunc(password, class) >= 0 {
+			classes++
+		}
+	}
+	if classes < rootPasswordMinClasses {
+		problems = append(problems, fmt.Sprintf("at least %d of lower case, upper case, digit and punctuation characters", rootPasswordMinClasses))
+	}
+
+	return problems
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
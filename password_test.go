@@ -0,0 +1,126 @@
+package linode
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/taoh/linodego"
+)
+
+func TestRootPasswordProblems(t *testing.T) {
+	length := "at least 11 characters long"
+	classes := "at least 3 of lower case, upper case, digit and punctuation characters"
+
+	tests := []struct {
+		password string
+		problems []string
+	}{
+		{"Sup3rSecretPass", nil},
+		{"lower-case-1", nil},
+		{"UPPER_lower!", nil},
+		{"Abcdefghij1", nil},
+		{"Abc1!", []string{length}},
+		{"abcdefghijkl", []string{classes}},
+		{"abcdefghij12", []string{classes}},
+		{"ABCDEFGHIJKl", []string{classes}},
+		{"abc", []string{length, classes}},
+		{"", []string{length, classes}},
+	}
+
+	for _, test := range tests {
+		if problems := rootPasswordProblems(test.password); !reflect.DeepEqual(problems, test.problems) {
+			t.Errorf("%q: expected %v, got %v", test.password, test.problems, problems)
+		}
+	}
+}
+
+func TestGenerateRootPassword(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		password, err := generateRootPassword()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if problems := rootPasswordProblems(password); len(problems) > 0 {
+			t.Errorf("generated password %q must be %v", password, problems)
+		}
+	}
+}
+
+func TestSaveRootPassword(t *testing.T) {
+	d := newStoreDriver(t)
+	d.RootPassword = "Generated-Passw0rd"
+
+	if err := d.saveRootPassword(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	info, err := os.Stat(d.ResolveStorePath(rootPasswordFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("expected mode 0600, got %o", mode)
+	}
+
+	password, err := d.GetRootPassword()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if password != d.RootPassword {
+		t.Errorf("expected %q to be read back, got %q", d.RootPassword, password)
+	}
+}
+
+func TestGetRootPasswordMissing(t *testing.T) {
+	d := newStoreDriver(t)
+
+	if _, err := d.GetRootPassword(); err == nil || !strings.HasPrefix(err.Error(), "reading generated root password:") {
+		t.Errorf("expected a read error, got %v", err)
+	}
+}
+
+// A password passed with --linode-root-pass is known to the user and must
+// not be written to the store, a generated one must
+func TestCreateSavesGeneratedRootPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		saved    bool
+	}{
+		{name: "generated", saved: true},
+		{name: "user supplied", password: "Sup3rSecretPass"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newStoreDriver(t)
+			if err := d.SetConfigFromFlags(newTestFlags(d, map[string]interface{}{
+				"linode-root-pass": test.password,
+			})); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// fails at the first API call, after the password was saved
+			d.clientOnce.Do(func() {
+				d.client = linodego.NewClient(d.APIKey, &http.Client{Transport: &fakeAPI{}})
+			})
+			if err := d.Create(); err == nil {
+				t.Fatal("expected create to fail")
+			}
+
+			password, err := d.GetRootPassword()
+			if !test.saved {
+				if err == nil {
+					t.Errorf("expected no root password file, got %q", password)
+				}
+				return
+			}
+			if err != nil || password != d.RootPassword {
+				t.Errorf("expected the generated password to be saved, got %q, %v", password, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package linode
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultAPIRate = 5
+
+// rateLimiter spaces requests evenly at a number of requests per second
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// apiLimiter is shared by the drivers of the process. docker-machine runs a
+// plugin process per machine, so the rate is per machine: creating machines
+// in parallel adds up their rates.
+var apiLimiter = &rateLimiter{interval: time.Second / defaultAPIRate}
+
+// setRate changes the number of requests per second, --linode-api-rate -1
+// (or any rate below 1) disables the limit
+func (l *rateLimiter) setRate(perSecond int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if perSecond <= 0 {
+		l.interval = 0
+		return
+	}
+	l.interval = time.Second / time.Duration(perSecond)
+}
+
+// wait blocks until the next request is allowed
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// rateLimitedTransport waits for the limiter before each request
+type rateLimitedTransport struct {
+	limiter *rateLimiter
+	base    http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.limiter.wait()
+	return t.base.RoundTrip(req)
+}
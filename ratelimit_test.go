@@ -0,0 +1,78 @@
+package linode
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterThrottles(t *testing.T) {
+	tests := []struct {
+		rate     int
+		calls    int
+		expected time.Duration
+	}{
+		// the first call isn't delayed, the others are spaced by the interval
+		{rate: 20, calls: 11, expected: 500 * time.Millisecond},
+		{rate: 50, calls: 26, expected: 500 * time.Millisecond},
+		{rate: 0, calls: 100, expected: 0},
+	}
+
+	for _, test := range tests {
+		limiter := &rateLimiter{}
+		limiter.setRate(test.rate)
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < test.calls; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				limiter.wait()
+			}()
+		}
+		wg.Wait()
+
+		elapsed := time.Since(start)
+		if elapsed < test.expected || elapsed > test.expected+250*time.Millisecond {
+			t.Errorf("%d calls at %d/s: expected %s, took %s", test.calls, test.rate, test.expected, elapsed)
+		}
+	}
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	limiter := &rateLimiter{}
+	limiter.setRate(10)
+
+	var times []time.Time
+	transport := &rateLimitedTransport{
+		limiter: limiter,
+		base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			times = append(times, time.Now())
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		}),
+	}
+
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post("https://api.linode.com/", "application/x-www-form-urlencoded", strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < 90*time.Millisecond {
+			t.Errorf("request %d was sent %s after the previous one, expected 100ms", i, gap)
+		}
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,35 @@
+package linode
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// dialTimeout is replaceable to dial the docker port of the machine
+var dialTimeout = net.DialTimeout
+
+// verifyDockerPort dials the docker port of the machine and logs whether it
+// can be reached. Docker isn't installed yet when the linode was just created,
+// so a refused connection only shows the port isn't filtered on the way.
+func (d *Driver) verifyDockerPort(timeout time.Duration) bool {
+	address := net.JoinHostPort(d.IPAddress, strconv.Itoa(d.DockerPort))
+	conn, err := dialTimeout("tcp", address, timeout)
+	if err == nil {
+		conn.Close()
+		log.Infof("Docker port %s is reachable", address)
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		log.Infof("Docker port %s is not filtered (connection refused), nothing is listening yet", address)
+		return true
+	}
+
+	log.Warnf("Docker port %s is not reachable, check the firewall allows it: %s", address, err)
+	return false
+}
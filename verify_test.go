@@ -0,0 +1,66 @@
+package linode
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyDockerPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer listener.Close()
+	listening := listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	refused := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		ip        string
+		port      int
+		reachable bool
+		message   string
+		dialErr   error
+	}{
+		{name: "listening", ip: "127.0.0.1", port: listening, reachable: true, message: "is reachable"},
+		{name: "refused", ip: "127.0.0.1", port: refused, reachable: true, message: "is not filtered (connection refused)"},
+		{
+			name:    "filtered",
+			ip:      "192.0.2.1",
+			port:    2376,
+			message: "Docker port 192.0.2.1:2376 is not reachable, check the firewall allows it: i/o timeout",
+			dialErr: errors.New("i/o timeout"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLog(t)
+			if test.dialErr != nil {
+				defer func(dial func(string, string, time.Duration) (net.Conn, error)) { dialTimeout = dial }(dialTimeout)
+				dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+					return nil, test.dialErr
+				}
+			}
+			d := NewDriver("default", "path")
+			d.IPAddress = test.ip
+			d.DockerPort = test.port
+
+			if reachable := d.verifyDockerPort(200 * time.Millisecond); reachable != test.reachable {
+				t.Errorf("expected reachable %v, got %v", test.reachable, reachable)
+			}
+			if !strings.Contains(logs.String(), test.message) {
+				t.Errorf("expected log %q, got %q", test.message, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package linode
+
+// Version of the driver, set at build time with
+// -ldflags "-X github.com/taoh/docker-machine-linode.Version=<version>"
+var Version = "dev"
+
+// capabilities lists the optional features supported by this build, sorted
+var capabilities = []string{
+	"adopt",
+	"alerts",
+	"api-key-file",
+	"auto-datacenter",
+	"boot-config",
+	"capacity-fallback",
+	"clone",
+	"config-devices",
+	"data-disk",
+	"dns-record",
+	"docker-port",
+	"eol-check",
+	"kernel",
+	"no-ssh-key",
+	"parallel-disks",
+	"plan-class",
+	"private-image",
+	"private-networking",
+	"rate-limit",
+	"rdns",
+	"resize-disk",
+	"rollback",
+	"shutdown-wait",
+	"ssh-over-private",
+	"swap-size",
+	"verbose-events",
+	"verify-docker-port",
+	"watchdog",
+}
+
+// GetVersion returns the driver version
+func GetVersion() string {
+	return Version
+}
+
+// Capabilities returns the optional features supported by the driver
+func Capabilities() []string {
+	return append([]string(nil), capabilities...)
+}
@@ -0,0 +1,69 @@
+package linode
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestVersionDefault(t *testing.T) {
+	if version := GetVersion(); version != "dev" {
+		t.Errorf("expected version dev without -ldflags, got %s", version)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	listed := make(map[string]bool)
+	for _, capability := range Capabilities() {
+		listed[capability] = true
+	}
+
+	for _, capability := range []string{
+		"adopt",
+		"alerts",
+		"api-key-file",
+		"auto-datacenter",
+		"boot-config",
+		"capacity-fallback",
+		"clone",
+		"config-devices",
+		"data-disk",
+		"dns-record",
+		"docker-port",
+		"eol-check",
+		"kernel",
+		"no-ssh-key",
+		"parallel-disks",
+		"plan-class",
+		"private-image",
+		"private-networking",
+		"rate-limit",
+		"rdns",
+		"resize-disk",
+		"rollback",
+		"shutdown-wait",
+		"ssh-over-private",
+		"swap-size",
+		"verbose-events",
+		"verify-docker-port",
+		"watchdog",
+	} {
+		if !listed[capability] {
+			t.Errorf("capability %s is not listed", capability)
+		}
+	}
+}
+
+func TestCapabilitiesSorted(t *testing.T) {
+	if !sort.StringsAreSorted(capabilities) {
+		t.Errorf("capabilities are not sorted: %v", capabilities)
+	}
+}
+
+func TestCapabilitiesCopy(t *testing.T) {
+	first := Capabilities()
+	first[0] = "changed"
+
+	if capabilities[0] == "changed" || Capabilities()[0] == "changed" {
+		t.Errorf("Capabilities returns the list of the driver instead of a copy")
+	}
+}
@@ -0,0 +1,47 @@
+package linode
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	waitInitialInterval = 500 * time.Millisecond
+	waitMaxInterval     = 10 * time.Second
+)
+
+// waitBackoff returns the interval to sleep after the given one, doubling it
+// up to waitMaxInterval
+func waitBackoff(interval time.Duration) time.Duration {
+	interval *= 2
+	if interval > waitMaxInterval {
+		return waitMaxInterval
+	}
+	return interval
+}
+
+// waitFor calls check until it reports done, starting with a short interval
+// and backing off, until timeOutSeconds have passed
+func waitFor(name string, timeOutSeconds int, check func() (bool, error)) error {
+	deadline := time.Now().Add(time.Duration(timeOutSeconds) * time.Second)
+	interval := waitInitialInterval
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("%s timed out after %d seconds.", name, timeOutSeconds)
+		}
+		if interval > remaining {
+			interval = remaining
+		}
+		time.Sleep(interval)
+		interval = waitBackoff(interval)
+	}
+}
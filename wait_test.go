@@ -0,0 +1,77 @@
+package linode
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitBackoff(t *testing.T) {
+	expected := []time.Duration{
+		500 * time.Millisecond,
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+
+	interval := waitInitialInterval
+	for i, e := range expected {
+		if interval != e {
+			t.Fatalf("interval %d: expected %s, got %s", i, e, interval)
+		}
+		interval = waitBackoff(interval)
+	}
+}
+
+func TestWaitForDone(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	err := waitFor("test", 60, func() (bool, error) {
+		calls++
+		return calls == 2, nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 checks, got %d", calls)
+	}
+	// only the initial interval is waited for
+	if elapsed := time.Since(start); elapsed > 2*waitInitialInterval {
+		t.Errorf("expected to return after %s, took %s", waitInitialInterval, elapsed)
+	}
+}
+
+func TestWaitForError(t *testing.T) {
+	calls := 0
+	err := waitFor("test", 60, func() (bool, error) {
+		calls++
+		return false, errors.New("failed")
+	})
+
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("expected the check error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 check, got %d", calls)
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	start := time.Now()
+	err := waitFor("test", 1, func() (bool, error) {
+		return false, nil
+	})
+
+	if err == nil || err.Error() != "test timed out after 1 seconds." {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	// the last interval is cut short at the deadline
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Errorf("expected to time out after 1s, took %s", elapsed)
+	}
+}